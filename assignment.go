@@ -0,0 +1,189 @@
+package chash
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/exp/slices"
+)
+
+// PartitionChange describes members gained and lost by a partition
+type PartitionChange struct {
+	PartitionId int
+	Added       []string
+	Removed     []string
+}
+
+// Diff compares two partition tables (see CHash.Partitions) and returns changes of partitions with different members
+func Diff[M Member](old, new [][]M) []PartitionChange {
+	var count = len(old)
+	if len(new) > count {
+		count = len(new)
+	}
+	var changes []PartitionChange
+	for i := 0; i < count; i++ {
+		var oldIds, newIds []string
+		if i < len(old) {
+			oldIds = memberIds(old[i])
+		}
+		if i < len(new) {
+			newIds = memberIds(new[i])
+		}
+		var change = PartitionChange{PartitionId: i}
+		for _, id := range newIds {
+			if !slices.Contains(oldIds, id) {
+				change.Added = append(change.Added, id)
+			}
+		}
+		for _, id := range oldIds {
+			if !slices.Contains(newIds, id) {
+				change.Removed = append(change.Removed, id)
+			}
+		}
+		if len(change.Added) != 0 || len(change.Removed) != 0 {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+func memberIds[M Member](ms []M) []string {
+	var ids = make([]string, len(ms))
+	for i, m := range ms {
+		ids[i] = m.Id()
+	}
+	return ids
+}
+
+func (c *cHash[M]) RebalanceCost(members []M) (int, error) {
+	scratch := c.Clone()
+	if err := scratch.Reconfigure(members); err != nil {
+		return 0, err
+	}
+	return movedSlots(c.Partitions(), scratch.Partitions()), nil
+}
+
+func (c *cHash[M]) Simulate(members []M) (partitions [][]M, stats BalanceStats, err error) {
+	scratch := c.Clone()
+	if err = scratch.Reconfigure(members); err != nil {
+		return
+	}
+	return scratch.Partitions(), scratch.BalanceStats(), nil
+}
+
+func (c *cHash[M]) Equal(other CHashG[M]) bool {
+	if c.EffectiveReplicationFactor() != other.EffectiveReplicationFactor() {
+		return false
+	}
+	var partitions, otherPartitions = c.Partitions(), other.Partitions()
+	return len(partitions) == len(otherPartitions) && movedSlots(partitions, otherPartitions) == 0
+}
+
+func (c *cHash[M]) Similarity(other CHashG[M]) float64 {
+	var partitions, otherPartitions = c.Partitions(), other.Partitions()
+	var slots int
+	for i := 0; i < len(partitions) || i < len(otherPartitions); i++ {
+		var n int
+		if i < len(partitions) {
+			n = len(partitions[i])
+		}
+		if i < len(otherPartitions) && len(otherPartitions[i]) > n {
+			n = len(otherPartitions[i])
+		}
+		slots += n
+	}
+	if slots == 0 {
+		return 1
+	}
+	return 1 - float64(movedSlots(partitions, otherPartitions))/float64(slots)
+}
+
+func (c *cHash[M]) Checksum() uint64 {
+	var buf []byte
+	for partId, ms := range c.Partitions() {
+		buf = binary.AppendUvarint(buf, uint64(partId))
+		buf = binary.AppendUvarint(buf, uint64(len(ms)))
+		ids := memberIds(ms)
+		slices.Sort(ids)
+		for _, id := range ids {
+			// ids are length prefixed, so ["ab"] and ["a", "b"] give different data
+			buf = binary.AppendUvarint(buf, uint64(len(id)))
+			buf = append(buf, id...)
+		}
+	}
+	return c.config.Hasher.Sum64(buf)
+}
+
+// movedSlots counts partition slots having different members in two tables
+func movedSlots[M Member](old, new [][]M) (moved int) {
+	for i := 0; i < len(old) || i < len(new); i++ {
+		var oldMs, newMs []M
+		if i < len(old) {
+			oldMs = old[i]
+		}
+		if i < len(new) {
+			newMs = new[i]
+		}
+		for j := 0; j < len(oldMs) || j < len(newMs); j++ {
+			if j >= len(oldMs) || j >= len(newMs) || oldMs[j].Id() != newMs[j].Id() {
+				moved++
+			}
+		}
+	}
+	return
+}
+
+func (c *cHash[M]) Partitions() [][]M {
+	return c.snapshot.Load().table()
+}
+
+func (c *cHash[M]) ExportAssignment() ([]byte, error) {
+	var partitions = c.snapshot.Load().table()
+	var assignment = make(map[string][]string, len(partitions))
+	for i, ms := range partitions {
+		assignment[strconv.Itoa(i)] = memberIds(ms)
+	}
+	return json.Marshal(assignment)
+}
+
+func (c *cHash[M]) ImportAssignment(data []byte) error {
+	var assignment map[string][]string
+	if err := json.Unmarshal(data, &assignment); err != nil {
+		return err
+	}
+	c.lock()
+	defer c.unlock()
+	if len(assignment) != len(c.partitionHashes) {
+		return fmt.Errorf("assignment has %d partitions, expected %d", len(assignment), len(c.partitionHashes))
+	}
+	rf := c.effectiveReplicationFactor()
+	var partitions = make([][]M, len(c.partitionHashes))
+	var table = make([]M, len(c.partitionHashes)*rf)
+	for i := range partitions {
+		ids, ok := assignment[strconv.Itoa(i)]
+		if !ok {
+			return ErrPartitionNotExists
+		}
+		if len(ids) != rf {
+			return fmt.Errorf("partition %d has %d members, expected %d", i, len(ids), rf)
+		}
+		if rf == 0 {
+			continue
+		}
+		partitions[i] = table[i*rf : (i+1)*rf : (i+1)*rf]
+		for j, id := range ids {
+			m, ok := c.members[id]
+			if !ok {
+				return ErrMemberNotExists
+			}
+			if slices.Contains(ids[:j], id) {
+				return fmt.Errorf("partition %d has duplicated member %s", i, id)
+			}
+			partitions[i][j] = m
+		}
+	}
+	c.publish(partitions)
+	return nil
+}
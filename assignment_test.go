@@ -0,0 +1,333 @@
+package chash
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+func TestCHash_ExportAssignment(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+
+	data, err := h.ExportAssignment()
+	require.NoError(t, err)
+	var assignment map[string][]string
+	require.NoError(t, json.Unmarshal(data, &assignment))
+	require.Len(t, assignment, h.PartitionCount())
+	for i := 0; i < h.PartitionCount(); i++ {
+		ms, _ := h.GetPartitionMembers(i)
+		require.Len(t, assignment[strconv.Itoa(i)], 2)
+		for j, m := range ms {
+			assert.Equal(t, m.Id(), assignment[strconv.Itoa(i)][j])
+		}
+	}
+
+	data2, err := h.ExportAssignment()
+	require.NoError(t, err)
+	assert.Equal(t, data, data2)
+}
+
+func TestCHash_ImportAssignment(t *testing.T) {
+	var members = []Member{testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}}
+	h1, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h1.AddMembers(members...))
+	data, err := h1.ExportAssignment()
+	require.NoError(t, err)
+
+	t.Run("import", func(t *testing.T) {
+		h2, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+			Hasher:            fnvHasher{},
+		})
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(members...))
+		require.NoError(t, h2.ImportAssignment(data))
+		for i := 0; i < h1.PartitionCount(); i++ {
+			ms1, _ := h1.GetPartitionMembers(i)
+			ms2, _ := h2.GetPartitionMembers(i)
+			assert.Equal(t, ms1, ms2)
+		}
+	})
+	t.Run("unknown member", func(t *testing.T) {
+		h2, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(members[0], members[1], testMember{id: "4", cap: 1}))
+		assert.Equal(t, ErrMemberNotExists, h2.ImportAssignment(data))
+	})
+	t.Run("invalid replication", func(t *testing.T) {
+		h2, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 3,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(members...))
+		assert.Error(t, h2.ImportAssignment(data))
+	})
+	t.Run("invalid partition count", func(t *testing.T) {
+		h2, err := New(Config{
+			PartitionCount:    10,
+			ReplicationFactor: 2,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(members...))
+		assert.Error(t, h2.ImportAssignment(data))
+	})
+}
+
+func TestCHash_RebalanceCost(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 1,
+	})
+	require.NoError(t, err)
+	var members []Member
+	for i := 0; i < 9; i++ {
+		members = append(members, testMember{id: fmt.Sprint("n", i), cap: 1})
+	}
+	require.NoError(t, h.AddMembers(members...))
+	before := h.Partitions()
+
+	cost, err := h.RebalanceCost(append(members, testMember{id: "n9", cap: 1}))
+	require.NoError(t, err)
+	// the new member takes about a tenth of partitions, some slots are shifted between old members
+	assert.GreaterOrEqual(t, cost, 100/10)
+	assert.LessOrEqual(t, cost, 2*100/10)
+	assert.Equal(t, before, h.Partitions())
+	assert.Equal(t, 9, h.MemberCount())
+
+	cost, err = h.RebalanceCost(members)
+	require.NoError(t, err)
+	assert.Equal(t, 0, cost)
+
+	_, err = h.RebalanceCost([]Member{testMember{id: "n0", cap: 0}})
+	assert.Equal(t, ErrInvalidCapacity, err)
+}
+
+func TestCHash_Simulate(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	var members []Member
+	for i := 0; i < 5; i++ {
+		members = append(members, testMember{id: fmt.Sprint("n", i), cap: float64(i + 1)})
+	}
+	require.NoError(t, h.AddMembers(members...))
+	before, version := h.Partitions(), h.Version()
+
+	partitions, stats, err := h.Simulate(members)
+	require.NoError(t, err)
+	assert.Equal(t, before, partitions)
+	assert.Equal(t, h.BalanceStats(), stats)
+
+	partitions, stats, err = h.Simulate(append(members, testMember{id: "n5", cap: 10}))
+	require.NoError(t, err)
+	assert.Len(t, partitions, 100)
+	assert.Greater(t, stats.MaxPartitions, h.BalanceStats().MaxPartitions)
+	assert.Equal(t, before, h.Partitions())
+	assert.Equal(t, version, h.Version())
+	assert.Equal(t, 5, h.MemberCount())
+
+	_, _, err = h.Simulate([]Member{testMember{id: "n0", cap: 0}})
+	assert.Equal(t, ErrInvalidCapacity, err)
+}
+
+func TestDiff(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 1,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, h.AddMembers(testMember{id: strconv.Itoa(i), cap: 1}))
+	}
+	before := h.Partitions()
+	assert.Empty(t, Diff(before, h.Partitions()))
+
+	require.NoError(t, h.AddMembers(testMember{id: "new", cap: 1}))
+	changes := Diff(before, h.Partitions())
+	assert.NotEmpty(t, changes)
+	assert.Less(t, len(changes), 50)
+	var added int
+	for _, ch := range changes {
+		require.Len(t, ch.Added, 1)
+		require.Len(t, ch.Removed, 1)
+		assert.NotEqual(t, ch.Added[0], ch.Removed[0])
+		ms, _ := h.GetPartitionMembers(ch.PartitionId)
+		assert.Equal(t, ch.Added[0], ms[0].Id())
+		if ch.Added[0] == "new" {
+			added++
+		}
+	}
+	assert.NotZero(t, added)
+	t.Logf("changed partitions: %d; moved to the new member: %d", len(changes), added)
+}
+
+func TestCHash_Equal(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}))
+	assert.True(t, h.Equal(h))
+
+	t.Run("clone", func(t *testing.T) {
+		clone := h.Clone()
+		assert.True(t, h.Equal(clone))
+		require.NoError(t, clone.RemoveMembers("3"))
+		assert.False(t, h.Equal(clone))
+	})
+	t.Run("round trip", func(t *testing.T) {
+		data, err := h.MarshalBinary()
+		require.NoError(t, err)
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.UnmarshalBinary(data))
+		assert.True(t, h.Equal(h2))
+	})
+	t.Run("another hasher", func(t *testing.T) {
+		h2, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+			Hasher:            fnvHasher{},
+		})
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}))
+		assert.False(t, h.Equal(h2))
+	})
+	t.Run("another replication factor", func(t *testing.T) {
+		clone := h.Clone()
+		require.NoError(t, clone.SetReplicationFactor(3))
+		assert.False(t, h.Equal(clone))
+	})
+}
+
+func TestCHash_Similarity(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    1000,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	var members []Member
+	for i := 0; i < 10; i++ {
+		members = append(members, testMember{id: fmt.Sprint(i), cap: 1})
+	}
+	require.NoError(t, h.AddMembers(members...))
+	assert.Equal(t, float64(1), h.Similarity(h.Clone()))
+
+	other := h.Clone()
+	require.NoError(t, other.AddMembers(testMember{id: "10", cap: 1}))
+	similarity := h.Similarity(other)
+	assert.Less(t, similarity, float64(1))
+	assert.Greater(t, similarity, 0.7)
+	assert.Equal(t, similarity, other.Similarity(h))
+	cost, err := h.RebalanceCost(append(members, testMember{id: "10", cap: 1}))
+	require.NoError(t, err)
+	assert.Equal(t, 1-float64(cost)/2000, similarity)
+
+	empty, err := New(Config{PartitionCount: 1000, ReplicationFactor: 2})
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), h.Similarity(empty))
+	assert.Equal(t, float64(1), empty.Similarity(empty.Clone()))
+}
+
+func TestCHash_Checksum(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	empty := h.Checksum()
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}))
+	sum := h.Checksum()
+	assert.NotEqual(t, empty, sum)
+	assert.Equal(t, sum, h.Checksum())
+
+	t.Run("clone", func(t *testing.T) {
+		assert.Equal(t, sum, h.Clone().Checksum())
+	})
+	t.Run("add order", func(t *testing.T) {
+		var members []Member
+		for i := 0; i < 30; i++ {
+			// weights whose sums depend on the order of additions
+			members = append(members, testMember{id: fmt.Sprint("m", i), cap: 0.1 + float64(i%7)/3})
+		}
+		tableIds := func(h CHash) (ids [][]string) {
+			for _, ms := range h.Partitions() {
+				ids = append(ids, memberIds(ms))
+			}
+			return
+		}
+		for _, c := range []Config{
+			{PartitionCount: 1000, ReplicationFactor: 3, MultiplyFactor: 100},
+			{PartitionCount: 1000, ReplicationFactor: 3, MultiplyFactor: 100, LoadFactor: 1.1},
+			{PartitionCount: 1000, ReplicationFactor: 3, MultiplyFactor: 100, MaxPartitionsPerMember: 150},
+		} {
+			h1, err := New(c)
+			require.NoError(t, err)
+			require.NoError(t, h1.AddMembers(members...))
+			for i := 0; i < 5; i++ {
+				shuffled := slices.Clone(members)
+				rand.New(rand.NewSource(int64(i))).Shuffle(len(shuffled), func(i, j int) {
+					shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+				})
+				h2, err := New(c)
+				require.NoError(t, err)
+				for _, m := range shuffled {
+					require.NoError(t, h2.AddMembers(m))
+				}
+				assert.Equal(t, h1.Checksum(), h2.Checksum())
+				assert.Equal(t, tableIds(h1), tableIds(h2))
+				assert.Equal(t, h1.BalanceStats(), h2.BalanceStats())
+			}
+		}
+	})
+	t.Run("member swap", func(t *testing.T) {
+		clone := h.Clone()
+		partitions := clone.Partitions()
+		assignment := make(map[string][]string, len(partitions))
+		for i, ms := range partitions {
+			assignment[fmt.Sprint(i)] = memberIds(ms)
+		}
+		assignment["0"][0], assignment["0"][1] = assignment["0"][1], assignment["0"][0]
+		data, err := json.Marshal(assignment)
+		require.NoError(t, err)
+		require.NoError(t, clone.ImportAssignment(data))
+		// only members of partitions are hashed, not their replica order
+		assert.Equal(t, sum, clone.Checksum())
+		assert.False(t, h.Equal(clone))
+
+		// replace the primary of the partition by the member it doesn't contain
+		for _, id := range []string{"1", "2", "3"} {
+			if !slices.Contains(assignment["1"], id) {
+				assignment["1"][0] = id
+			}
+		}
+		assignment["0"][0], assignment["0"][1] = assignment["0"][1], assignment["0"][0]
+		data, err = json.Marshal(assignment)
+		require.NoError(t, err)
+		require.NoError(t, clone.ImportAssignment(data))
+		assert.NotEqual(t, sum, clone.Checksum())
+	})
+}
@@ -1,20 +1,35 @@
 package chash
 
 import (
+	"context"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
 	"errors"
 	"fmt"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
+	"math"
+	"runtime"
 	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/cespare/xxhash"
 )
 
 var (
-	ErrMemberExists       = errors.New("member exists")
-	ErrMemberNotExists    = errors.New("member not exists")
-	ErrPartitionNotExists = errors.New("partition not exists")
-	ErrInvalidCapacity    = errors.New("member capacity must be > 0")
+	ErrMemberExists        = errors.New("member exists")
+	ErrMemberNotExists     = errors.New("member not exists")
+	ErrPartitionNotExists  = errors.New("partition not exists")
+	ErrInvalidCapacity     = errors.New("member capacity must be > 0")
+	ErrInvalidWeight       = errors.New("member weight must be > 0")
+	ErrInsufficientMembers = errors.New("members count is less than replication factor")
+	ErrNotStaged           = errors.New("no staged reconfiguration")
+	ErrNoMembers           = errors.New("no members for the key")
 )
 
 type defaultHasher struct{}
@@ -23,7 +38,113 @@ func (h defaultHasher) Sum64(data []byte) uint64 {
 	return xxhash.Sum64(data)
 }
 
+func (h defaultHasher) Sum64String(s string) uint64 {
+	return xxhash.Sum64String(s)
+}
+
+func (h defaultHasher) Sum64UUID(key [16]byte) uint64 {
+	return xxhash.Sum64(key[:])
+}
+
+// stringHasher may be implemented by a Hasher to hash strings without converting them to bytes
+type stringHasher interface {
+	Sum64String(s string) uint64
+}
+
+// checkHasher returns an error if the hasher returns the same hash for a few distinct inputs, such a hasher would put all keys to one partition
+func checkHasher(h Hasher) error {
+	var first = h.Sum64([]byte("p0"))
+	for _, sample := range []string{"p1", "a", "b", "member", "key"} {
+		if h.Sum64([]byte(sample)) != first {
+			return nil
+		}
+	}
+	return fmt.Errorf("hasher returns the same hash for distinct inputs")
+}
+
+// sum64String hashes the string by the hasher avoiding the conversion to bytes when it's possible
+func sum64String(h Hasher, s string) uint64 {
+	if sh, ok := h.(stringHasher); ok {
+		return sh.Sum64String(s)
+	}
+	return h.Sum64([]byte(s))
+}
+
+// uuidHasher may be implemented by a Hasher to hash 16 byte keys passed by value, so the key doesn't escape to the heap
+type uuidHasher interface {
+	Sum64UUID(key [16]byte) uint64
+}
+
+// sum64UUID hashes the key like the hasher hashes its 16 bytes
+func sum64UUID(h Hasher, key [16]byte) uint64 {
+	if uh, ok := h.(uuidHasher); ok {
+		return uh.Sum64UUID(key)
+	}
+	// only the copy escapes, so the key stays on the stack for uuidHasher implementations
+	buf := key
+	return h.Sum64(buf[:])
+}
+
+// seededHasher folds the seed into hashes of the wrapped hasher
+type seededHasher struct {
+	Hasher
+	seed uint64
+}
+
+func (h seededHasher) Sum64(data []byte) uint64 {
+	return mix64(h.Hasher.Sum64(data) ^ h.seed)
+}
+
+func (h seededHasher) Sum64String(s string) uint64 {
+	return mix64(sum64String(h.Hasher, s) ^ h.seed)
+}
+
+func (h seededHasher) Sum64UUID(key [16]byte) uint64 {
+	return mix64(sum64UUID(h.Hasher, key) ^ h.seed)
+}
+
+// unsafeStringHasher passes bytes of strings to the wrapped hasher without copying them, see Config.UnsafeStringHash
+type unsafeStringHasher struct {
+	Hasher
+}
+
+func (h unsafeStringHasher) Sum64String(s string) uint64 {
+	// the slice shares memory of the string, it's never written and isn't kept by hashers
+	return h.Hasher.Sum64(*(*[]byte)(unsafe.Pointer(&struct {
+		string
+		int
+	}{s, len(s)})))
+}
+
+func (h unsafeStringHasher) Sum64UUID(key [16]byte) uint64 {
+	return sum64UUID(h.Hasher, key)
+}
+
+// stringHashing wraps the hasher to hash strings without copying when it's enabled and the hasher can't hash strings itself
+func stringHashing(h Hasher, unsafeStrings bool) Hasher {
+	if _, ok := h.(stringHasher); ok || !unsafeStrings {
+		return h
+	}
+	return unsafeStringHasher{Hasher: h}
+}
+
+// New creates a ring configured by the given config
 func New(c Config) (CHash, error) {
+	return NewG[Member](c)
+}
+
+// NewWithOptions creates a ring configured by the given options
+// The partition count must be set with WithPartitionCount, the other options are optional
+func NewWithOptions(opts ...Option) (CHash, error) {
+	var c Config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return New(c)
+}
+
+// NewG creates a ring storing members of the type M
+func NewG[M Member](c Config) (CHashG[M], error) {
 	if c.Hasher == nil {
 		c.Hasher = defaultHasher{}
 	}
@@ -33,34 +154,265 @@ func New(c Config) (CHash, error) {
 	if c.MultiplyFactor <= 0 {
 		c.MultiplyFactor = defaultMultiplyFactor
 	}
-	h := &cHash{config: c}
+	h := &cHash[M]{config: c}
 	if err := h.init(); err != nil {
 		return nil, err
 	}
 	return h, nil
 }
 
-type CHash interface {
+// CHash is a ring storing members as the Member interface
+type CHash = CHashG[Member]
+
+// CHashG is a ring storing members of the concrete type M
+// Use it with your own member type to get members back without type assertions
+type CHashG[M Member] interface {
+	// AddMembersDeferred validates members and keeps them until Commit without changing the ring
+	// It's useful for bootstrapping a ring with many members by many calls, partitions are distributed only once by Commit
+	// May return the same errors as AddMembers
+	AddMembersDeferred(members ...M) error
+	// Commit adds members deferred by AddMembersDeferred to the ring and distributes partitions
+	// May return ErrInsufficientMembers when Config.RequireFullReplication is set, deferred members are kept then
+	Commit() error
 	// AddMembers adds one or more members to the cluster
-	// May return ErrInvalidCapacity if member capacity less or equal 0
+	// May return ErrInvalidCapacity if member capacity less or equal 0, NaN or infinite
+	// May return ErrInvalidWeight if member implements Weighted and its weight less or equal 0
 	// May return ErrMemberExists if member was added before
-	AddMembers(members ...Member) error
+	AddMembers(members ...M) error
+	// AddMembersFunc adds members returned by next until it returns false and distributes partitions once
+	// Members are validated as they come, the first error stops reading and the ring stays unchanged then
+	// May return the same errors as AddMembers
+	AddMembersFunc(next func() (M, bool)) error
 	// RemoveMembers removes members with given ids
 	RemoveMembers(memberIds ...string) error
-	// Reconfigure replaces all members list
-	Reconfigure(members []Member) error
+	// ReplaceMember replaces the member with the given one distributing partitions once
+	// The new member takes positions of the old one on the ring, so it gets the old member's partitions when their weights are equal
+	// The capacity set by UpdateCapacity and the drained state of the old member are dropped, inherited positions are encoded by MarshalBinary
+	// May return ErrMemberNotExists, ErrInvalidCapacity, ErrInvalidWeight or ErrMemberExists if the new id belongs to another member
+	ReplaceMember(oldId string, m M) error
+	// MoveMember renames the member with oldId to the id of the given member keeping partitions of the member unchanged
+	// The member keeps its positions on the ring, the capacity set by UpdateCapacity, the drained and the standby state and its pinned keys, positions are encoded by MarshalBinary
+	// Partitions aren't distributed, so placement changes only when the member's weight changed and partitions are distributed again
+	// May return the same errors as ReplaceMember
+	MoveMember(oldId string, m M) error
+	// RemoveMember removes the given member, it works like RemoveMembers(m.Id())
+	RemoveMember(m M) error
+	// Reconfigure replaces all members list, members deferred by AddMembersDeferred are dropped
+	// May return ErrInvalidCapacity, ErrInvalidWeight or ErrMemberExists if ids are not unique, the ring is unchanged then
+	Reconfigure(members []M) error
+	// Clear removes all members including deferred ones
+	Clear()
+	// Clone returns an independent copy of the ring, changes of the copy don't affect the original
+	// The copy doesn't call Config.OnRebalance
+	Clone() CHashG[M]
 	// GetMembers returns list of members for given key
 	// Members count will be equal replication factor or total members count (if it is less than the replication factor)
-	GetMembers(key string) []Member
+	// Members are in the replica order of the key's partition, so all keys of a partition get the same order and the first member is the primary.
+	// The default placement orders members as they are picked walking the ring clockwise from the partition position, so the primary is
+	// the closest member having partitions left to own. With Strategy the order is the one returned by Strategy.Assign.
+	// The order depends only on members and the config, not on the order members were added, and changes only with the placement.
+	// The returned slice is shared with the ring and must not be modified
+	GetMembers(key string) []M
+	// GetMembersE works like GetMembers but returns ErrNoMembers instead of an empty result, e.g. when the ring is empty or all members are drained
+	GetMembersE(key string) ([]M, error)
+	// PinKey makes GetMembers, GetMembersBytes, GetMembersMany and methods using them like GetPrimary return only the member for the key
+	// regardless of partitions, ErrMemberNotExists is returned for unknown members. The pin is dropped when the member is removed.
+	// Other lookups, partition methods and encodings of the ring don't use pins, clones keep them.
+	PinKey(key, memberId string) error
+	// UnpinKey drops the pin of the key, so it's placed by its partition again
+	UnpinKey(key string)
+	// GetMembersInto copies members for given key into buf, growing it when needed, and returns the result
+	// Unlike GetMembers the result isn't shared with the ring
+	GetMembersInto(key string, buf []M) []M
+	// GetMembersBytes works like GetMembers but accepts the key as bytes and doesn't allocate
+	GetMembersBytes(key []byte) []M
+	// GetMembersUUID works like GetMembersBytes with the 16 bytes of the key, e.g. a UUID, without allocations
+	// The key is hashed as bytes, so it's not the same as GetMembers with the canonical string form of a UUID
+	GetMembersUUID(key [16]byte) []M
+	// GetMembersByHash works like GetMembers but accepts an already computed hash of the key
+	// The hash is used as is, Config.Seed isn't applied to it
+	GetMembersByHash(h uint64) []M
+	// GetMembersN returns up to n distinct members for given key regardless of the replication factor
+	// The first members are the same as GetMembers returns, others are the next members on the ring
+	GetMembersN(key string, n int) []M
+	// GetMembersExcluding returns members for given key except the excluded ones
+	// Excluded members are replaced with the next members on the ring to keep the replication factor when possible
+	GetMembersExcluding(key string, exclude ...string) []M
+	// GetMembersMany returns members for every key from the same partitions table
+	// Inner slices are shared with the ring like the GetMembers result and must not be modified
+	GetMembersMany(keys []string) [][]M
+	// GetPrimary returns the first member for given key, false when there are no members
+	GetPrimary(key string) (M, bool)
+	// GetWriteQuorum returns the first w members for given key, w is limited by the members count of the key
+	// The returned slice is shared with the ring like the GetMembers result
+	GetWriteQuorum(key string, w int) []M
+	// GetReadQuorum returns the first r members for given key like GetWriteQuorum does
+	GetReadQuorum(key string, r int) []M
+	// QuorumSatisfiable checks that every read quorum of r members overlaps every write quorum of w members for the replication factor
+	QuorumSatisfiable(w, r int) bool
+	// Successor returns the first member on the ring starting from the position of the key clockwise
+	// False is returned when there are no members
+	Successor(key string) (M, bool)
+	// Predecessor returns the nearest member on the ring before the position of the key that differs from the Successor
+	// It's the same as the Successor when there is only one member
+	Predecessor(key string) (M, bool)
+	// KeyPosition returns the position of the key on the ring, it's the hash virtual members are compared with
+	KeyPosition(key string) uint64
+	// MemberPositions returns sorted positions of virtual members of the member, nil if the member doesn't exist
+	MemberPositions(id string) []uint64
+	// GetMembersPrevious returns members for given key from the partitions table published before the current one
+	// It allows reading from old or new owners while data migrates after a change, the next change replaces the previous table
+	// The result is the same as GetMembers for a ring that wasn't changed after creation
+	GetMembersPrevious(key string) []M
+	// GetMembersWithPartition returns partition number and members for given key, both from the same partitions table
+	// The returned slice is shared with the ring like the GetMembers result
+	GetMembersWithPartition(key string) (int, []M)
 	// GetPartition returns partition number for given key
 	GetPartition(key string) int
-	// GetPartitionMembers return members by partition number
-	GetPartitionMembers(partId int) ([]Member, error)
+	// GetPartitionUUID returns partition number for the 16 byte key like GetMembersUUID does
+	GetPartitionUUID(key [16]byte) int
+	// GetPartitionByHash returns partition number for given hash of a key, like GetMembersByHash does
+	GetPartitionByHash(h uint64) int
+	// HashRangesForPartition returns key hashes of the partition, so a scan can filter keys of the partition by hash, nil if the partition doesn't exist
+	// A partition is the key hash modulo partition count, so its hashes aren't contiguous: they're every partition count-th hash, see HashRange
+	HashRangesForPartition(partId int) []HashRange
+	// GetPartitionMembers return a copy of members by partition number
+	GetPartitionMembers(partId int) ([]M, error)
+	// GetPartitionMembersInto copies members of the partition into buf and returns the number of copied members
+	// buf should be at least replication factor length to fit all members
+	GetPartitionMembersInto(partId int, buf []M) (int, error)
+	// RangePartitions calls f for every partition in order until it returns false, all partitions are from the same table
+	// Members aren't copied, so they're shared with the ring like the GetMembers result and must not be modified
+	RangePartitions(f func(partId int, members []M) bool)
 	// Distribute members by partitions
 	// Must be called if you changed members' capacity
 	Distribute()
+	// DistributeCtx works like Distribute but stops when the context is done and returns its error
+	// The previous distribution is kept in that case
+	DistributeCtx(ctx context.Context) error
+	// ReplicaChangeViolations returns ids of partitions that lost more prior owners than Config.MaxReplicaChangePerPartition by the last distribution
+	ReplicaChangeViolations() []int
+	// Flush distributes partitions postponed by Config.CoalesceWindow at once, it's a no-op when nothing is postponed
+	Flush()
+	// DistributeIfChanged distributes partitions only when MarkDirty was called or capacities of members were changed since the last distribution
+	// It returns whether partitions were distributed
+	DistributeIfChanged() bool
+	// MarkDirty makes the next DistributeIfChanged distribute partitions, e.g. after a change DistributeIfChanged can't see
+	MarkDirty()
 	// PartitionCount returns configured partitions count
 	PartitionCount() int
+	// ReplicationFactor returns the configured replication factor
+	ReplicationFactor() int
+	// EffectiveReplicationFactor returns the replication factor limited by count of members that can own partitions
+	EffectiveReplicationFactor() int
+	// SetReplicationFactor changes the replication factor and redistributes partitions
+	SetReplicationFactor(rf int) error
+	// SetPartitionCount changes partitions count and redistributes partitions
+	// Keys are mapped to partitions by modulo of the partitions count, so most keys will change their partition
+	SetPartitionCount(n uint64) error
+	// Namespace creates a view of the ring with its own partitions table distributed with the given replication factor
+	// The view shares members with the ring, so membership changes of the ring are applied to all namespaces
+	// Namespaces live as long as the ring and every one of them makes the distribution longer, clones of the ring have no namespaces
+	// May return ErrInsufficientMembers when Config.RequireFullReplication is set and there are less members than rf,
+	// changes of the ring leaving less members than the replication factor of any namespace are rejected then too
+	Namespace(rf int) (NamespaceG[M], error)
+	// Version returns the counter incremented every time the ring publishes a new distribution
+	// A ring created by New has version 0
+	Version() uint64
+	// Watch returns a channel receiving the new version after every change of the ring
+	// Versions are coalesced when the receiver is slow, so only the latest one is delivered
+	// The channel is never closed and is kept by the ring, clones of the ring don't send to it
+	Watch() <-chan uint64
+	// StageReconfigure computes the partitions table the ring would have after Reconfigure with given members without changing the ring
+	// Lookups use the current table until CommitStaged, a staged table replaces the previous one and is dropped by any other change of the ring
+	// May return the same errors as Reconfigure
+	StageReconfigure(members []M) error
+	// PendingDiff returns changes of partitions the staged reconfiguration would make, nil when nothing is staged
+	PendingDiff() []PartitionChange
+	// CommitStaged applies the staged reconfiguration publishing its partitions table at once
+	// May return ErrNotStaged when nothing is staged or the staged table was dropped
+	CommitStaged() error
+	// AbortStaged drops the staged reconfiguration
+	AbortStaged()
+	// Members returns a snapshot of all members sorted by id
+	Members() []M
+	// MemberCount returns count of members
+	MemberCount() int
+	// GetMember returns member by id
+	GetMember(id string) (M, bool)
+	// ContainsMember checks whether member with given id was added
+	ContainsMember(id string) bool
+	// UpdateCapacity changes capacity of the member and redistributes partitions
+	// The given capacity overrides the member's weight (see Weighted) until the member is removed or reconfigured
+	// Only virtual members over the smaller count are added or removed, so partitions move to or from the member only. The fair shares of other
+	// members change too, so with the default placement a member over its new share may pass about one partition on.
+	// May return ErrMemberNotExists or ErrInvalidCapacity
+	UpdateCapacity(id string, capacity float64) error
+	// DrainMember makes the member own no partitions while keeping it in the ring, e.g. during decommissioning
+	DrainMember(id string) error
+	// UndrainMember returns a drained member to partitions distribution
+	UndrainMember(id string) error
+	// Promote makes the standby member (see Standby) own partitions like other members
+	// It's a no-op for members that are not standby, may return ErrMemberNotExists
+	Promote(id string) error
+	// Partitions returns members of all partitions
+	// The returned table is shared with the ring and must not be modified, it stays unchanged after redistribution
+	Partitions() [][]M
+	// PartitionsOwnedBy returns sorted numbers of partitions containing the member
+	PartitionsOwnedBy(id string) []int
+	// LoadDistribution returns the fraction of all partition slots owned by every member
+	LoadDistribution() map[string]float64
+	// BalanceStats returns statistics of partitions count per member
+	BalanceStats() BalanceStats
+	// RebalanceCost returns how many partition slots would get another member if the ring was reconfigured with given members
+	// The ring itself isn't changed
+	RebalanceCost(members []M) (int, error)
+	// Simulate returns the partitions table and its balance the ring would have if it was reconfigured with given members
+	// The ring itself isn't changed
+	Simulate(members []M) (partitions [][]M, stats BalanceStats, err error)
+	// Equal checks that both rings have the same partitions count, effective replication factor and members of every partition
+	// Members are compared by id, so rings restored by UnmarshalBinary are equal to the original ones
+	Equal(other CHashG[M]) bool
+	// Similarity returns the fraction of partition slots having the same member in both rings, 1 for equal tables and 0 for tables sharing nothing
+	// Slots are compared by position like RebalanceCost does, so a partition of fewer members in one ring has unmatched slots
+	Similarity(other CHashG[M]) float64
+	// Checksum returns a hash of sorted member ids of every partition in the partitions order, so the replica order doesn't change it
+	// Rings with equal placement (see Equal) have the same checksum, it's computed by Config.Hasher
+	Checksum() uint64
+	// TopMembersByLoad returns up to n members owning the most partitions sorted by partitions count descending and then by id
+	TopMembersByLoad(n int) []MemberLoadG[M]
+	// OrphanMembers returns sorted ids of members owning no partitions, e.g. when there are more members than partition slots
+	// Drained members are orphans too
+	OrphanMembers() []string
+	// ReplicationGraph returns sorted ids of members sharing at least one partition with every member
+	// The graph is symmetric, members sharing no partitions have empty lists
+	ReplicationGraph() map[string][]string
+	// UnderReplicatedPartitions returns sorted numbers of partitions having less members than the configured replication factor
+	UnderReplicatedPartitions() []int
+	// Stats returns counters describing the ring, all of them are gathered at once
+	Stats() Stats
+	// Dump returns a human-readable description of the config, members and partitions for debugging
+	Dump() string
+	// ExportAssignment returns JSON with member ids by partition number: {"0":["n1","n3"],"1":[...]}
+	ExportAssignment() ([]byte, error)
+	// ImportAssignment replaces partition members with the ones from JSON returned by ExportAssignment
+	// All referenced members must be added before, the imported table is used until the next distribution
+	ImportAssignment(data []byte) error
+	// MarshalBinary encodes the config and members of the ring including drained and standby ones and positions inherited by ReplaceMember, the Hasher isn't encoded
+	encoding.BinaryMarshaler
+	// UnmarshalBinary replaces the config and members with decoded ones keeping the current Hasher
+	// Decoded members are SerializableMember, so only a ring created by New can decode them
+	encoding.BinaryUnmarshaler
+	// GobEncode encodes the ring like MarshalBinary does
+	gob.GobEncoder
+	// GobDecode decodes the ring like UnmarshalBinary does
+	gob.GobDecoder
+	// MarshalProto encodes the ring as the RingState protobuf message of ringstate.proto, so services in other languages can use it
+	// Besides MarshalBinary data it has the partition table and the config affecting placement, the Hasher is only named when it's the default one
+	MarshalProto() ([]byte, error)
+	// UnmarshalProto replaces the config and members with ones decoded from RingState keeping the current Hasher, KeyHasher and Strategy
+	// Partitions are computed from members, ErrHasherMismatch is returned for data of the default hasher when the ring has another one
+	UnmarshalProto(data []byte) error
 }
 
 type Member interface {
@@ -68,6 +420,25 @@ type Member interface {
 	Capacity() float64
 }
 
+// Weighted may be implemented by a Member to define its share of partitions apart from the capacity
+// When it's implemented, the weight drives the distribution and the capacity only has to be greater than 0 for the member to be accepted
+type Weighted interface {
+	Weight() float64
+}
+
+// Standby may be implemented by a Member to add it as a hot spare
+// A standby member owns no partitions until it's promoted by Promote or there are less other members than the replication factor,
+// then standby members are used in id order to cover the missing replicas
+type Standby interface {
+	Standby() bool
+}
+
+// Zoned may be implemented by a Member to define its failure domain (zone, rack, etc.)
+// Replicas of a partition are placed to members of different zones while there are enough zones
+type Zoned interface {
+	Zone() string
+}
+
 type Hasher interface {
 	Sum64([]byte) uint64
 }
@@ -81,228 +452,1861 @@ type Config struct {
 	ReplicationFactor int
 	// Multiply Factor (optional) - this value multiplied for member capacity means how many times a member will be added to the hash ring. The default value is 2000.
 	MultiplyFactor int
+	// MaxVirtualMembers (optional) - the most virtual members a member gets regardless of its capacity, the default value is 1048576.
+	// It keeps the ring bounded for extreme capacities, partitions are still shared by capacities, so members over the limit only own less even parts of the ring.
+	MaxVirtualMembers int
+	// LoadFactor (optional) - when set, a member never owns more than ceil(fair share * LoadFactor) partitions, where fair share is proportional to member's capacity.
+	// Must be greater or equal 1, a typical value is 1.25. The bound is exceeded only when a partition can't get enough distinct members otherwise.
+	LoadFactor float64
+	// Strategy (optional) - how members are placed to partitions, by default members are placed using the hash ring of virtual members
+	Strategy Strategy
+	// Parallel (optional) - walk the ring for all partitions using all CPUs while distributing, members are then taken serially while they have pieces left. The result is the same as with serial distribution.
+	Parallel bool
+	// RequireFullReplication (optional) - when set, changes leaving less members owning partitions than ReplicationFactor
+	// are rejected with ErrInsufficientMembers and the ring stays unchanged. Members covering the replication factor must be added at once then.
+	RequireFullReplication bool
+	// OverflowTolerance (optional) - how many partitions over its fair share a member may own, 0 means the default value 1 and negative values are rejected.
+	// Lower values give more even distribution, higher values move less partitions when members are changed. It isn't used with LoadFactor.
+	// Shares are rounded down, so the tightest tolerance is requested by any value close to 0, e.g. math.SmallestNonzeroFloat64.
+	OverflowTolerance float64
+	// KeyCacheSize (optional) - when set, partitions of that many recently used string keys are cached, it helps when some keys are very hot.
+	// Partitions of keys don't depend on members, so the cache is reset only when the partition count is changed.
+	// A cache lookup costs about as much as hashing a short key with the default hasher, so it pays off with an expensive KeyHasher.
+	KeyCacheSize int
+	// Lazy (optional) - when set, members of a partition are computed on the first access to it instead of filling all partitions by every change.
+	// Members are placed like with an unlimited OverflowTolerance, so the load of members depends only on their virtual members.
+	// Methods returning all partitions and OnRebalance compute every partition. It can't be used with LoadFactor, MaxPartitionsPerMember or Strategy.
+	Lazy bool
+	// MaxPartitionsPerMember (optional) - when set, no member owns more partitions regardless of its capacity, the rest goes to the next members on the ring.
+	// Partitions get less members than ReplicationFactor when capped members leave not enough others. It isn't used with Strategy.
+	MaxPartitionsPerMember int
+	// MaxReplicaChangePerPartition (optional) - when set, a distribution keeps prior owners of a partition, so it loses at most that many of them.
+	// Kept owners replace new members from the last replica, ignoring load bounds and zones, so later distributions move the rest step by step.
+	// Partitions losing more, e.g. because owners were removed, are returned by ReplicaChangeViolations. Namespaces aren't limited, it can't be used with Lazy.
+	MaxReplicaChangePerPartition int
+	// CoalesceWindow (optional) - when set, partitions are distributed after no members were changed for that long instead of after every change.
+	// Members are changed at once, so Members and MemberCount see them, but lookups use the previous partitions until the distribution or Flush.
+	// The distribution isn't postponed more than 10 windows after the first change. Clones of the ring and SetPartitionCount distribute at once.
+	CoalesceWindow time.Duration
+	// OnRebalance (optional) - called after a change of the ring with ids of partitions whose members or their order were changed.
+	// It's called outside of the ring lock, so it may use the ring.
+	OnRebalance func(changed []int)
+	// KeyHasher (optional) - when set, it is used to find a partition for a key, while Hasher is still used to build the ring.
+	// It allows keys to be hashed the same way as in another system.
+	KeyHasher Hasher
+	// NormalizeCapacity (optional) - when set, virtual members are counted for weights divided by the smallest weight of members,
+	// so only ratios of capacities matter and raw values like disk bytes don't produce more virtual members. Changes of the smallest weight
+	// create virtual members of all members again, AddMembersFunc keeps members until the generator is done then.
+	NormalizeCapacity bool
+	// UnsafeStringHash (optional) - when set, string keys are passed to a Hasher without Sum64String(string) uint64 without copying them to bytes,
+	// so lookups don't allocate. The hasher must neither modify nor keep the passed slice. The default hasher never copies strings anyway.
+	UnsafeStringHash bool
+	// Seed (optional) - when set, it is folded into all hashes, so rings with different seeds place keys and members independently.
+	// Changing the seed reshuffles all keys and partitions. The seed isn't encoded by MarshalBinary.
+	Seed uint64
 }
 
 func (c Config) Validate() (err error) {
 	if c.ReplicationFactor < 1 {
-		return fmt.Errorf("replcation factor must be great or equal 1")
+		return fmt.Errorf("replication factor must be greater or equal 1")
 	}
 	if c.PartitionCount < 10 {
-		return fmt.Errorf("patiotin count must be great ir qual 10")
+		return fmt.Errorf("partition count must be greater or equal 10")
+	}
+	if c.MultiplyFactor < 1 {
+		return fmt.Errorf("multiply factor must be greater or equal 1")
+	}
+	if c.MaxVirtualMembers < 0 {
+		return fmt.Errorf("max virtual members must be greater or equal 0")
+	}
+	if c.LoadFactor != 0 && c.LoadFactor < 1 {
+		return fmt.Errorf("load factor must be greater or equal 1")
+	}
+	if c.OverflowTolerance < 0 {
+		return fmt.Errorf("overflow tolerance must be greater or equal 0")
+	}
+	if c.KeyCacheSize < 0 {
+		return fmt.Errorf("key cache size must be greater or equal 0")
+	}
+	if c.MaxPartitionsPerMember < 0 {
+		return fmt.Errorf("max partitions per member must be greater or equal 0")
+	}
+	if c.MaxReplicaChangePerPartition < 0 {
+		return fmt.Errorf("max replica change per partition must be greater or equal 0")
+	}
+	if c.Lazy && (c.LoadFactor != 0 || c.MaxPartitionsPerMember != 0 || c.Strategy != nil || c.MaxReplicaChangePerPartition != 0) {
+		return fmt.Errorf("lazy mode can't be used with load factor, max partitions per member, strategy or max replica change per partition")
 	}
 	return
 }
 
-const defaultMultiplyFactor = 2000
+const (
+	defaultMultiplyFactor    = 2000
+	defaultMaxVirtualMembers = 1 << 20
+	defaultOverflowTolerance = 1
+)
 
-type cHash struct {
-	config          Config
-	members         map[string]Member
-	membersSet      members
+type cHash[M Member] struct {
+	config     Config
+	hasher     Hasher
+	keyHasher  Hasher
+	members    map[string]M
+	capacities map[string]float64
+	membersSet members[M]
+	drained    map[string]struct{}
+	// standby are ids of standby members that are not promoted
+	standby map[string]struct{}
+	// spares are ids of standby members owning partitions instead of missing members, idleStandby counts other standby members
+	spares          map[string]struct{}
+	idleStandby     int
+	pending         []M
+	namespaces      []*namespace[M]
 	piecesPerMember map[string]int
-	partitions      [][]Member
+	ownedPieces     map[string]int
+	zones           map[string]string
+	zoneCount       int
 	partitionHashes []uint64
-	mu              sync.RWMutex
+	// positionIds are ids whose virtual keys give positions of members replaced by ReplaceMember
+	positionIds map[string]string
+	// unit is the weight of one MultiplyFactor of virtual members with Config.NormalizeCapacity
+	unit float64
+	// weights are weights of members used by the last distribution, dirty is set by MarkDirty
+	weights map[string]float64
+	dirty   bool
+	// keyGen is changed every time partitions of keys are changed
+	keyGen   uint64
+	keyCache *keyCache
+	snapshot atomic.Pointer[snapshot[M]]
+	// locked is the snapshot published before the write lock was taken
+	locked   *snapshot[M]
+	coalesce coalescing
+	// staged is the copy of the ring reconfigured by StageReconfigure
+	staged *cHash[M]
+	// violations are partitions of the last distribution losing more prior owners than Config.MaxReplicaChangePerPartition
+	violations []int
+	// pins are members of keys pinned by PinKey, they're replaced on write, so lookups read them without the lock
+	pins     atomic.Pointer[map[string][]M]
+	mu       sync.RWMutex
+	watchers watchers
+}
+
+// snapshot is an immutable result of distribute, readers use it without locking
+type snapshot[M Member] struct {
+	partitions [][]M
+	hasher     Hasher
+	// mask is not 0 when the partitions count is a power of two
+	mask uint64
+	// keyGen is the generation of key partitions, see keyCache
+	keyGen   uint64
+	keyCache *keyCache
+	// flat contains members of all partitions one by one when every partition has stride members
+	// lookups take members from it by one multiplication instead of loading the slice of the partition
+	flat   []M
+	stride int
+	// version is the count of snapshots published before this one
+	version uint64
+	// previous is the snapshot replaced by this one, its own previous snapshot is dropped
+	previous *snapshot[M]
+	// lazy computes members of partitions in the lazy mode, partitions are empty then
+	lazy *lazyPartitions[M]
+}
+
+// members returns members of the partition
+func (s *snapshot[M]) members(partId int) []M {
+	if s.stride == 0 {
+		if s.lazy != nil {
+			return s.lazy.members(partId)
+		}
+		return s.partitions[partId]
+	}
+	off := partId * s.stride
+	return s.flat[off : off+s.stride : off+s.stride]
+}
+
+// table returns members of all partitions, partitions of a lazy snapshot are computed first
+func (s *snapshot[M]) table() [][]M {
+	if s.lazy != nil {
+		return s.lazy.table()
+	}
+	return s.partitions
+}
+
+// partition returns partition number for given key
+func (s *snapshot[M]) partition(key []byte) int {
+	return s.partitionByHash(s.hasher.Sum64(key))
+}
+
+// partitionString returns partition number for given key using the key cache when it's enabled
+func (s *snapshot[M]) partitionString(key string) int {
+	if s.keyCache == nil {
+		return s.partitionByHash(sum64String(s.hasher, key))
+	}
+	if partition, ok := s.keyCache.get(key, s.keyGen); ok {
+		return partition
+	}
+	partition := s.partitionByHash(sum64String(s.hasher, key))
+	s.keyCache.put(key, s.keyGen, partition)
+	return partition
+}
+
+// partitionByHash returns partition number for given hash of a key
+func (s *snapshot[M]) partitionByHash(h uint64) int {
+	if s.mask != 0 {
+		return int(h & s.mask)
+	}
+	return int(h % uint64(len(s.partitions)))
+}
+
+func (c *cHash[M]) init() (err error) {
+	if err = checkConfig(c.config); err != nil {
+		return
+	}
+	c.initState()
+	c.publish(make([][]M, c.config.PartitionCount))
+	return
 }
 
-func (c *cHash) init() (err error) {
-	if err = c.config.Validate(); err != nil {
+// checkConfig validates the config and its hashers
+func checkConfig(config Config) (err error) {
+	if err = config.Validate(); err != nil {
+		return
+	}
+	if err = checkHasher(config.Hasher); err != nil {
 		return
 	}
-	c.members = make(map[string]Member)
+	if config.KeyHasher != nil {
+		if err = checkHasher(config.KeyHasher); err != nil {
+			return fmt.Errorf("key %w", err)
+		}
+	}
+	return
+}
+
+// initState resets members and hashers of the ring for its config without publishing anything
+func (c *cHash[M]) initState() {
+	c.members = make(map[string]M)
+	c.capacities = make(map[string]float64)
+	c.drained = make(map[string]struct{})
+	c.standby = make(map[string]struct{})
+	c.spares, c.idleStandby = nil, 0
+	c.positionIds = make(map[string]string)
+	c.pending = nil
+	c.unit = 0
+	c.hasher = stringHashing(c.config.Hasher, c.config.UnsafeStringHash)
+	if c.config.Seed != 0 {
+		c.hasher = seededHasher{Hasher: c.hasher, seed: c.config.Seed}
+	}
+	c.keyHasher = c.hasher
+	if c.config.KeyHasher != nil {
+		c.keyHasher = stringHashing(c.config.KeyHasher, c.config.UnsafeStringHash)
+		if c.config.Seed != 0 {
+			c.keyHasher = seededHasher{Hasher: c.keyHasher, seed: c.config.Seed}
+		}
+	}
+	c.keyCache = nil
+	if c.config.KeyCacheSize > 0 {
+		c.keyCache = newKeyCache(c.config.KeyCacheSize)
+	}
+	c.initPartitionHashes()
+}
+
+func (c *cHash[M]) initPartitionHashes() {
+	c.keyGen++
 	c.partitionHashes = make([]uint64, c.config.PartitionCount)
-	c.partitions = make([][]Member, c.config.PartitionCount)
+	var buf = make([]byte, 0, 24)
 	for i := range c.partitionHashes {
-		c.partitionHashes[i] = c.config.Hasher.Sum64([]byte(fmt.Sprint("p", i)))
+		buf = strconv.AppendInt(append(buf[:0], 'p'), int64(i), 10)
+		c.partitionHashes[i] = c.hasher.Sum64(buf)
 	}
-	return
 }
 
-func (c *cHash) AddMembers(members ...Member) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	for _, m := range members {
-		if m.Capacity() <= 0 {
-			return ErrInvalidCapacity
+func (c *cHash[M]) AddMembers(members ...M) error {
+	c.lock()
+	defer c.unlock()
+	if err := validateMembers(members, c.members); err != nil {
+		return err
+	}
+	if err := c.checkPending(members); err != nil {
+		return err
+	}
+	if err := c.checkReplication(c.availableCount()+len(members), c.config.ReplicationFactor); err != nil {
+		return err
+	}
+	return c.addMembers(members...)
+}
+
+func (c *cHash[M]) AddMembersFunc(next func() (M, bool)) error {
+	c.lock()
+	defer c.unlock()
+	var (
+		ms    []M
+		ids   = make(map[string]struct{})
+		added = c.newVirtualMembers()
+		buf   []byte
+		err   error
+	)
+	for m, ok := next(); ok; m, ok = next() {
+		if err = validateMember(m); err != nil {
+			return err
 		}
-		if _, ok := c.members[m.Id()]; ok {
+		if _, exists := c.members[m.Id()]; exists {
+			return ErrMemberExists
+		}
+		if _, exists := ids[m.Id()]; exists {
 			return ErrMemberExists
 		}
+		if err = c.checkPending([]M{m}); err != nil {
+			return err
+		}
+		ids[m.Id()] = struct{}{}
+		ms = append(ms, m)
+		if c.config.NormalizeCapacity {
+			// the unit isn't known until all members are seen
+			continue
+		}
+		if buf, err = c.appendVirtualMembers(&added, m, buf); err != nil {
+			return err
+		}
 	}
-	return c.addMembers(members...)
+	if err = c.checkReplication(c.availableCount()+len(ms), c.config.ReplicationFactor); err != nil {
+		return err
+	}
+	if len(ms) == 0 {
+		return nil
+	}
+	if c.config.NormalizeCapacity {
+		return c.addMembers(ms...)
+	}
+	c.insertMembers(ms, added)
+	return nil
+}
+
+func (c *cHash[M]) AddMembersDeferred(members ...M) error {
+	c.lock()
+	defer c.unlock()
+	if err := validateMembers(members, c.members); err != nil {
+		return err
+	}
+	if err := c.checkPending(members); err != nil {
+		return err
+	}
+	c.pending = append(c.pending, members...)
+	return nil
+}
+
+func (c *cHash[M]) Commit() error {
+	c.lock()
+	defer c.unlock()
+	if len(c.pending) == 0 {
+		return nil
+	}
+	if err := c.checkReplication(c.availableCount()+len(c.pending), c.config.ReplicationFactor); err != nil {
+		return err
+	}
+	if err := c.addMembers(c.pending...); err != nil {
+		return err
+	}
+	c.pending = nil
+	return nil
 }
 
-func (c *cHash) addMembers(members ...Member) error {
+// checkPending returns ErrMemberExists if any of members was deferred by AddMembersDeferred
+func (c *cHash[M]) checkPending(members []M) error {
 	for _, m := range members {
-		// generating enough virtual members for better hash distribution
-		for i := 0; i < int(float64(c.config.MultiplyFactor)*m.Capacity()); i++ {
-			c.membersSet = append(c.membersSet, member{
-				hash:   c.config.Hasher.Sum64([]byte(fmt.Sprint(m.Id(), i))),
-				Member: m,
-			})
+		if slices.ContainsFunc(c.pending, func(p M) bool {
+			return p.Id() == m.Id()
+		}) {
+			return ErrMemberExists
 		}
-		c.members[m.Id()] = m
 	}
-	sort.Sort(c.membersSet)
-	c.distribute()
 	return nil
 }
 
-func (c *cHash) RemoveMembers(memberIds ...string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// addMembers adds members to the ring, the ring stays unchanged on error
+func (c *cHash[M]) addMembers(ms ...M) error {
+	c.normalizeCapacity(ms)
+	added, err := c.virtualMembers(ms)
+	if err != nil {
+		return err
+	}
+	c.insertMembers(ms, added)
+	return nil
+}
 
-	for _, mId := range memberIds {
-		if _, ok := c.members[mId]; !ok {
-			return ErrMemberNotExists
+// insertMembers adds members having the given virtual members to the ring and distributes partitions
+func (c *cHash[M]) insertMembers(ms []M, added members[M]) {
+	for _, m := range ms {
+		c.members[m.Id()] = m
+		if isStandby(m) {
+			c.standby[m.Id()] = struct{}{}
 		}
 	}
-	discard := func(ids ...string) members {
-		idx := 0
-		for _, el := range c.membersSet {
-			if !slices.Contains(ids, el.Id()) {
-				c.membersSet[idx] = el
-				idx++
-			}
+	// sorting only new virtual members and merging them is much cheaper than sorting the whole ring again
+	sort.Sort(added)
+	c.membersSet = c.membersSet.merge(added)
+	c.distribute()
+}
+
+// virtualMembers builds virtual members of new members without changing the ring
+func (c *cHash[M]) virtualMembers(ms []M) (added members[M], err error) {
+	var buf []byte
+	added = c.newVirtualMembers()
+	for _, m := range ms {
+		if buf, err = c.appendVirtualMembers(&added, m, buf); err != nil {
+			return
 		}
-		return c.membersSet[:idx]
 	}
-	c.membersSet = discard(memberIds...)
-	for _, mId := range memberIds {
-		delete(c.members, mId)
+	return added, nil
+}
+
+// newVirtualMembers returns an empty set for virtual members of new members sharing the table of the ring
+func (c *cHash[M]) newVirtualMembers() members[M] {
+	if c.membersSet.Len() == 0 {
+		// the ring is empty, so reuse its backing arrays
+		return c.membersSet.reset()
 	}
-	c.distribute()
-	return nil
+	return members[M]{table: c.membersSet.table, ids: c.membersSet.ids}
 }
 
-func (c *cHash) Reconfigure(members []Member) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	for _, m := range members {
-		if m.Capacity() <= 0 {
-			return ErrInvalidCapacity
+// appendVirtualMembers adds the member and its virtual members to added, buf is reused for virtual keys and returned
+func (c *cHash[M]) appendVirtualMembers(added *members[M], m M, buf []byte) ([]byte, error) {
+	if w := c.weight(m); !(w > 0) || math.IsInf(w, 1) {
+		return buf, ErrInvalidWeight
+	}
+	// generating enough virtual members for better hash distribution
+	if n := c.virtualCount(m); n > 0 {
+		ref := added.addMember(m)
+		for i := 0; i < n; i++ {
+			buf = virtualKey(buf[:0], c.positionId(m.Id()), i)
+			added.add(c.hasher.Sum64(buf), ref)
 		}
 	}
-	c.members = make(map[string]Member)
-	c.membersSet = c.membersSet[:0]
-	return c.addMembers(members...)
+	return buf, nil
 }
 
-func (c *cHash) GetMembers(key string) []Member {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.partitions[c.getPartition(key)]
+// virtualCount returns how many virtual members will be added to the ring for the given member
+// every accepted member gets at least one, otherwise it would own nothing but still count in totalWeight, and at most Config.MaxVirtualMembers
+// virtual members are used only by the default placement, so there are none when Strategy is configured
+func (c *cHash[M]) virtualCount(m M) int {
+	if c.config.Strategy != nil {
+		return 0
+	}
+	w := c.weight(m)
+	if c.config.NormalizeCapacity && c.unit > 0 {
+		w /= c.unit
+	}
+	max := c.config.MaxVirtualMembers
+	if max == 0 {
+		max = defaultMaxVirtualMembers
+	}
+	// the count is compared as float, converting too big floats to int gives an arbitrary value
+	f := float64(c.config.MultiplyFactor) * w
+	if f >= float64(max) {
+		return max
+	}
+	n := int(f)
+	if n < 1 {
+		n = 1
+	}
+	return n
 }
 
-func (c *cHash) GetPartition(key string) int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.getPartition(key)
+// normalizeCapacity makes the smallest valid weight of members and the added ones the unit of virtualCount, see Config.NormalizeCapacity
+// virtual members of the ring are created again when the unit is changed, true is returned then
+func (c *cHash[M]) normalizeCapacity(added []M) bool {
+	if !c.config.NormalizeCapacity || c.config.Strategy != nil {
+		return false
+	}
+	var unit float64
+	var consider = func(m M) {
+		if w := c.weight(m); w > 0 && !math.IsInf(w, 1) && (unit == 0 || w < unit) {
+			unit = w
+		}
+	}
+	for _, m := range c.members {
+		consider(m)
+	}
+	for _, m := range added {
+		consider(m)
+	}
+	if unit == 0 || unit == c.unit {
+		return false
+	}
+	c.unit = unit
+	if len(c.members) != 0 {
+		var buf []byte
+		ring := c.membersSet.reset()
+		for _, id := range c.sortedIds() {
+			// weights of members were validated when they were added
+			buf, _ = c.appendVirtualMembers(&ring, c.members[id], buf)
+		}
+		sort.Sort(ring)
+		c.membersSet = ring
+	}
+	return true
 }
 
-func (c *cHash) PartitionCount() int {
-	return int(c.config.PartitionCount)
+// weight returns the capacity set by UpdateCapacity or the member's own weight
+func (c *cHash[M]) weight(m M) float64 {
+	if capacity, ok := c.capacities[m.Id()]; ok {
+		return capacity
+	}
+	if wm, ok := any(m).(Weighted); ok {
+		return wm.Weight()
+	}
+	return m.Capacity()
 }
 
-func (c *cHash) getPartition(key string) int {
-	h := c.config.Hasher.Sum64([]byte(key))
-	return int(h % c.config.PartitionCount)
+// validateMembers checks whether members can be added to the ring having the existing members
+// ids must be unique within members too
+func validateMembers[M Member](members []M, existing map[string]M) error {
+	var ids = make(map[string]struct{}, len(members))
+	for _, m := range members {
+		if err := validateMember(m); err != nil {
+			return err
+		}
+		if _, ok := existing[m.Id()]; ok {
+			return ErrMemberExists
+		}
+		if _, ok := ids[m.Id()]; ok {
+			return ErrMemberExists
+		}
+		ids[m.Id()] = struct{}{}
+	}
+	return nil
 }
 
-func (c *cHash) GetPartitionMembers(partId int) ([]Member, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	if partId < 0 || partId >= int(c.config.PartitionCount) {
-		return nil, ErrPartitionNotExists
+// validateMember checks whether the member can be added to the ring
+func validateMember(m Member) error {
+	if !(m.Capacity() > 0) || math.IsInf(m.Capacity(), 1) {
+		return ErrInvalidCapacity
+	}
+	if wm, ok := m.(Weighted); ok && (!(wm.Weight() > 0) || math.IsInf(wm.Weight(), 1)) {
+		return ErrInvalidWeight
 	}
-	return c.partitions[partId], nil
+	return nil
 }
 
-func (c *cHash) Distribute() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.distribute()
+// virtualKey appends the hash key of the i-th virtual member to buf
+// the index is written as fixed-width suffix, so ids sharing a prefix (like "a" and "a1") never produce the same key
+func virtualKey(buf []byte, id string, i int) []byte {
+	buf = append(buf, id...)
+	return binary.BigEndian.AppendUint64(buf, uint64(i))
 }
 
-func (c *cHash) distribute() {
-	if len(c.membersSet) == 0 {
-		for i := range c.partitions {
-			c.partitions[i] = nil
+func (c *cHash[M]) RemoveMembers(memberIds ...string) error {
+	c.lock()
+	defer c.unlock()
+
+	var removed = make(map[string]struct{}, len(memberIds))
+	for _, mId := range memberIds {
+		if _, ok := c.members[mId]; !ok {
+			return ErrMemberNotExists
+		}
+		if _, drained := c.drained[mId]; !drained {
+			removed[mId] = struct{}{}
 		}
-		return
-	}
-	var totalCapacity float64
-	rf := c.config.ReplicationFactor
-	if len(c.members) < rf {
-		rf = len(c.members)
 	}
-	for _, m := range c.members {
-		totalCapacity += m.Capacity()
+	if err := c.checkReplication(c.availableCount()-len(removed), c.config.ReplicationFactor); err != nil {
+		return err
 	}
-	c.piecesPerMember = map[string]int{}
-	for _, m := range c.members {
-		p := int((float64(c.config.PartitionCount)*float64(rf))/(totalCapacity/m.Capacity())) + 1
-		c.piecesPerMember[m.Id()] = p
+	c.membersSet = c.membersSet.remove(memberIds...).compact()
+	for _, mId := range memberIds {
+		delete(c.members, mId)
+		delete(c.capacities, mId)
+		delete(c.drained, mId)
+		delete(c.standby, mId)
+		delete(c.positionIds, mId)
 	}
+	c.distribute()
+	return nil
+}
 
-	var buf = make([]string, rf)
-	for i, h := range c.partitionHashes {
-		if len(c.partitions[i]) != rf {
-			c.partitions[i] = make([]Member, rf)
-		}
-		c.fillClosest(c.membersSet, h, c.partitions[i], buf)
+func (c *cHash[M]) RemoveMember(m M) error {
+	return c.RemoveMembers(m.Id())
+}
+
+func (c *cHash[M]) Reconfigure(members []M) error {
+	c.lock()
+	defer c.unlock()
+	if err := validateMembers(members, map[string]M(nil)); err != nil {
+		return err
+	}
+	if err := c.checkReplication(len(members), c.config.ReplicationFactor); err != nil {
+		return err
 	}
+	c.members = make(map[string]M)
+	c.capacities = make(map[string]float64)
+	c.drained = make(map[string]struct{})
+	c.standby = make(map[string]struct{})
+	c.positionIds = make(map[string]string)
+	c.pending = nil
+	c.membersSet = c.membersSet.reset()
+	if err := c.addMembers(members...); err != nil {
+		return err
+	}
+	// the ring may shrink, so arrays reused after reset may be much longer than needed
+	c.membersSet = c.membersSet.compact()
+	return nil
 }
 
-func (c *cHash) fillClosest(m members, h uint64, ms []Member, buf []string) {
-	idx := sort.Search(len(m), func(i int) bool {
-		return m[i].hash >= h
-	})
-	var found int
-	var maxOverflow int
-	var foundId = buf[:0]
+func (c *cHash[M]) Clear() {
+	c.lock()
+	defer c.unlock()
+	c.members = make(map[string]M)
+	c.capacities = make(map[string]float64)
+	c.drained = make(map[string]struct{})
+	c.standby = make(map[string]struct{})
+	c.positionIds = make(map[string]string)
+	c.pending = nil
+	c.membersSet = c.membersSet.reset()
+	c.distribute()
+}
 
-	var isAlreadyFound = func(id string) bool {
-		return slices.Contains(foundId, id)
+func (c *cHash[M]) Clone() CHashG[M] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.clone()
+}
+
+// clone copies the ring without its namespaces, watchers and OnRebalance, the lock must be held
+func (c *cHash[M]) clone() *cHash[M] {
+	clone := &cHash[M]{
+		config:          c.config,
+		hasher:          c.hasher,
+		keyHasher:       c.keyHasher,
+		members:         maps.Clone(c.members),
+		capacities:      maps.Clone(c.capacities),
+		membersSet:      c.membersSet.clone(),
+		drained:         maps.Clone(c.drained),
+		standby:         maps.Clone(c.standby),
+		spares:          maps.Clone(c.spares),
+		idleStandby:     c.idleStandby,
+		weights:         maps.Clone(c.weights),
+		dirty:           c.dirty,
+		positionIds:     maps.Clone(c.positionIds),
+		unit:            c.unit,
+		pending:         slices.Clone(c.pending),
+		piecesPerMember: maps.Clone(c.piecesPerMember),
+		zones:           maps.Clone(c.zones),
+		zoneCount:       c.zoneCount,
+		partitionHashes: slices.Clone(c.partitionHashes),
+		keyGen:          c.keyGen,
+		violations:      slices.Clone(c.violations),
 	}
-	for found < len(ms) {
-		if idx == m.Len() {
-			idx = 0
-		}
-		if isAlreadyFound(m[idx].Id()) {
-			maxOverflow++
-			idx++
-			continue
+	if c.keyCache != nil {
+		clone.keyCache = newKeyCache(c.config.KeyCacheSize)
+	}
+	// changes of the clone are not changes of the original ring
+	clone.config.OnRebalance = nil
+	clone.config.CoalesceWindow = 0
+	// snapshot is immutable, so it can be shared
+	clone.snapshot.Store(c.snapshot.Load())
+	clone.pins.Store(c.pins.Load())
+	if c.coalesce.pending {
+		clone.distributeNow()
+	}
+	return clone
+}
+
+func (c *cHash[M]) UpdateCapacity(id string, capacity float64) error {
+	c.lock()
+	defer c.unlock()
+	m, ok := c.members[id]
+	if !ok {
+		return ErrMemberNotExists
+	}
+	if !(capacity > 0) || math.IsInf(capacity, 1) {
+		return ErrInvalidCapacity
+	}
+	prevCount := c.virtualCount(m)
+	c.capacities[id] = capacity
+	if !c.normalizeCapacity(nil) {
+		c.resizeVirtualMembers(id, prevCount, c.virtualCount(m))
+	}
+	c.distribute()
+	return nil
+}
+
+// resizeVirtualMembers adds or removes virtual members of the member, so it has newCount of them instead of prevCount
+// the first virtual members are kept, so the member keeps most of its positions
+func (c *cHash[M]) resizeVirtualMembers(id string, prevCount, newCount int) {
+	var (
+		buf        []byte
+		ref        = c.membersSet.ref(id)
+		positionId = c.positionId(id)
+	)
+	if newCount > prevCount {
+		for i := prevCount; i < newCount; i++ {
+			buf = virtualKey(buf[:0], positionId, i)
+			c.membersSet.add(c.hasher.Sum64(buf), ref)
 		}
-		if c.piecesPerMember[m[idx].Id()] > -maxOverflow {
-			c.piecesPerMember[m[idx].Id()]--
-			ms[found] = m[idx].Member
-			foundId = append(foundId, m[idx].Id())
-			found++
+		sort.Sort(c.membersSet)
+	} else if newCount < prevCount {
+		var trim = make(map[uint64]struct{}, prevCount-newCount)
+		for i := newCount; i < prevCount; i++ {
+			buf = virtualKey(buf[:0], positionId, i)
+			trim[c.hasher.Sum64(buf)] = struct{}{}
 		}
-		idx++
+		c.membersSet = c.membersSet.retain(func(hash uint64, r int32) bool {
+			_, ok := trim[hash]
+			return !ok || r != ref
+		})
 	}
 }
 
-type member struct {
-	hash uint64
-	Member
+// positionId returns the id whose virtual keys give positions of the member on the ring
+func (c *cHash[M]) positionId(id string) string {
+	if positionId, ok := c.positionIds[id]; ok {
+		return positionId
+	}
+	return id
 }
 
-type members []member
+func (c *cHash[M]) ReplaceMember(oldId string, m M) error {
+	c.lock()
+	defer c.unlock()
+	if err := c.checkReplacement(oldId, m); err != nil {
+		return err
+	}
+	c.swapMember(oldId, m, false)
+	c.distribute()
+	return nil
+}
 
-func (m members) Len() int {
-	return len(m)
+func (c *cHash[M]) MoveMember(oldId string, m M) error {
+	c.lock()
+	defer c.unlock()
+	if err := c.checkReplacement(oldId, m); err != nil {
+		return err
+	}
+	c.swapMember(oldId, m, true)
+	c.initZones()
+	c.publish(renamePartitions(c.snapshot.Load().table(), oldId, m))
+	for _, ns := range c.namespaces {
+		ns.snapshot.Store(c.newSnapshot(renamePartitions(ns.snapshot.Load().table(), oldId, m)))
+	}
+	return nil
 }
 
-func (m members) Less(i, j int) bool {
-	if m[i].hash == m[j].hash {
-		return m[i].Id() < m[j].Id()
-	} else {
-		return m[i].hash < m[j].hash
+// checkReplacement checks whether the member with oldId can be replaced by m
+func (c *cHash[M]) checkReplacement(oldId string, m M) error {
+	if _, ok := c.members[oldId]; !ok {
+		return ErrMemberNotExists
+	}
+	if err := validateMember(m); err != nil {
+		return err
+	}
+	if m.Id() != oldId {
+		if _, ok := c.members[m.Id()]; ok {
+			return ErrMemberExists
+		}
+		if err := c.checkPending([]M{m}); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (m members) Swap(i, j int) {
-	m[i], m[j] = m[j], m[i]
+// swapMember puts m to the place of the member with oldId, m takes positions of virtual members of the old member
+// keepState keeps the capacity set by UpdateCapacity, the drained and the standby state and pinned keys of the old member, otherwise m's own Standby is used
+func (c *cHash[M]) swapMember(oldId string, m M, keepState bool) {
+	var (
+		prevCount             = c.virtualCount(c.members[oldId])
+		positionId            = c.positionId(oldId)
+		ref                   = c.membersSet.ref(oldId)
+		capacity, capacitySet = c.capacities[oldId]
+		_, drained            = c.drained[oldId]
+		_, standby            = c.standby[oldId]
+		_, spare              = c.spares[oldId]
+	)
+	delete(c.members, oldId)
+	delete(c.capacities, oldId)
+	delete(c.drained, oldId)
+	delete(c.standby, oldId)
+	delete(c.spares, oldId)
+	delete(c.positionIds, oldId)
+	c.members[m.Id()] = m
+	if keepState && capacitySet {
+		c.capacities[m.Id()] = capacity
+	}
+	if keepState && drained {
+		c.drained[m.Id()] = struct{}{}
+	}
+	if keepState && standby || !keepState && isStandby(m) {
+		c.standby[m.Id()] = struct{}{}
+	}
+	if keepState && spare {
+		c.spares[m.Id()] = struct{}{}
+	}
+	if keepState {
+		c.renamePins(oldId, m)
+	}
+	if positionId != m.Id() {
+		c.positionIds[m.Id()] = positionId
+	}
+	if ref >= 0 {
+		c.membersSet.table[ref], c.membersSet.ids[ref] = m, m.Id()
+	}
+	newCount := c.virtualCount(m)
+	c.resizeVirtualMembers(m.Id(), prevCount, newCount)
+	if newCount <= prevCount {
+		// virtual members got another id, so virtual members with equal hashes may be ordered differently
+		sort.Sort(c.membersSet)
+	}
+}
+
+// renamePartitions returns a copy of partitions where the member with oldId is replaced by m
+func renamePartitions[M Member](partitions [][]M, oldId string, m M) [][]M {
+	var renamed = make([][]M, len(partitions))
+	for i, ms := range partitions {
+		renamed[i] = slices.Clone(ms)
+		for j := range renamed[i] {
+			if renamed[i][j].Id() == oldId {
+				renamed[i][j] = m
+			}
+		}
+	}
+	return renamed
+}
+
+func (c *cHash[M]) DrainMember(id string) error {
+	c.lock()
+	defer c.unlock()
+	if _, ok := c.members[id]; !ok {
+		return ErrMemberNotExists
+	}
+	if _, ok := c.drained[id]; ok {
+		return nil
+	}
+	if err := c.checkReplication(c.availableCount()-1, c.config.ReplicationFactor); err != nil {
+		return err
+	}
+	c.drained[id] = struct{}{}
+	c.distribute()
+	return nil
+}
+
+func (c *cHash[M]) UndrainMember(id string) error {
+	c.lock()
+	defer c.unlock()
+	if _, ok := c.members[id]; !ok {
+		return ErrMemberNotExists
+	}
+	if _, ok := c.drained[id]; !ok {
+		return nil
+	}
+	delete(c.drained, id)
+	c.distribute()
+	return nil
+}
+
+func (c *cHash[M]) Promote(id string) error {
+	c.lock()
+	defer c.unlock()
+	if _, ok := c.members[id]; !ok {
+		return ErrMemberNotExists
+	}
+	if _, ok := c.standby[id]; !ok {
+		return nil
+	}
+	delete(c.standby, id)
+	c.distribute()
+	return nil
+}
+
+// isStandby checks whether the member is added as a standby one
+func isStandby[M Member](m M) bool {
+	sm, ok := any(m).(Standby)
+	return ok && sm.Standby()
+}
+
+func (c *cHash[M]) GetMembers(key string) []M {
+	if ms, ok := c.pinned(key); ok {
+		return ms
+	}
+	s := c.snapshot.Load()
+	return s.members(s.partitionString(key))
+}
+
+func (c *cHash[M]) GetMembersBytes(key []byte) []M {
+	if p := c.pins.Load(); p != nil {
+		// the key is converted only for the lookup, so it isn't allocated
+		if ms, ok := (*p)[string(key)]; ok {
+			return ms
+		}
+	}
+	s := c.snapshot.Load()
+	return s.members(s.partition(key))
+}
+
+func (c *cHash[M]) GetMembersUUID(key [16]byte) []M {
+	s := c.snapshot.Load()
+	return s.members(s.partitionByHash(sum64UUID(s.hasher, key)))
+}
+
+func (c *cHash[M]) GetMembersByHash(h uint64) []M {
+	s := c.snapshot.Load()
+	return s.members(s.partitionByHash(h))
+}
+
+func (c *cHash[M]) GetPartition(key string) int {
+	return c.getPartition(key)
+}
+
+func (c *cHash[M]) GetPartitionUUID(key [16]byte) int {
+	s := c.snapshot.Load()
+	return s.partitionByHash(sum64UUID(s.hasher, key))
+}
+
+func (c *cHash[M]) GetPartitionByHash(h uint64) int {
+	return c.snapshot.Load().partitionByHash(h)
+}
+
+func (c *cHash[M]) PartitionCount() int {
+	return len(c.snapshot.Load().partitions)
+}
+
+func (c *cHash[M]) ReplicationFactor() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config.ReplicationFactor
+}
+
+func (c *cHash[M]) EffectiveReplicationFactor() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.effectiveReplicationFactor()
+}
+
+func (c *cHash[M]) SetReplicationFactor(rf int) error {
+	c.lock()
+	defer c.unlock()
+	config := c.config
+	config.ReplicationFactor = rf
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if err := c.checkReplication(c.availableCount(), rf); err != nil {
+		return err
+	}
+	c.config.ReplicationFactor = rf
+	c.distribute()
+	return nil
+}
+
+func (c *cHash[M]) SetPartitionCount(n uint64) error {
+	c.lock()
+	defer c.unlock()
+	config := c.config
+	config.PartitionCount = n
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	c.config.PartitionCount = n
+	c.initPartitionHashes()
+	// lookups find partitions by the published table but ring positions by partitionHashes, so both must change at once
+	c.distributeNow()
+	return nil
+}
+
+func (c *cHash[M]) Members() []M {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var result = make([]M, 0, len(c.members))
+	for _, m := range c.members {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id() < result[j].Id()
+	})
+	return result
+}
+
+// sortedIds returns ids of all members in stable order
+func (c *cHash[M]) sortedIds() []string {
+	var ids = make([]string, 0, len(c.members))
+	for id := range c.members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *cHash[M]) MemberCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.members)
+}
+
+func (c *cHash[M]) GetMember(id string) (M, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.members[id]
+	return m, ok
+}
+
+func (c *cHash[M]) ContainsMember(id string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.members[id]
+	return ok
+}
+
+func (c *cHash[M]) getPartition(key string) int {
+	return c.snapshot.Load().partitionString(key)
+}
+
+func (c *cHash[M]) GetPartitionMembers(partId int) ([]M, error) {
+	s := c.snapshot.Load()
+	if partId < 0 || partId >= len(s.partitions) {
+		return nil, ErrPartitionNotExists
+	}
+	return slices.Clone(s.members(partId)), nil
+}
+
+func (c *cHash[M]) GetPartitionMembersInto(partId int, buf []M) (int, error) {
+	s := c.snapshot.Load()
+	if partId < 0 || partId >= len(s.partitions) {
+		return 0, ErrPartitionNotExists
+	}
+	return copy(buf, s.members(partId)), nil
+}
+
+func (c *cHash[M]) RangePartitions(f func(partId int, members []M) bool) {
+	s := c.snapshot.Load()
+	for i := range s.partitions {
+		if !f(i, s.members(i)) {
+			return
+		}
+	}
+}
+
+func (c *cHash[M]) Distribute() {
+	c.lock()
+	defer c.unlock()
+	c.distributeNow()
+}
+
+func (c *cHash[M]) DistributeCtx(ctx context.Context) error {
+	c.lock()
+	defer c.unlock()
+	return c.distributeCtx(ctx)
+}
+
+func (c *cHash[M]) DistributeIfChanged() bool {
+	c.lock()
+	defer c.unlock()
+	if !c.dirty && !c.weightsChanged() {
+		return false
+	}
+	c.distributeNow()
+	return true
+}
+
+func (c *cHash[M]) MarkDirty() {
+	c.lock()
+	defer c.unlock()
+	c.dirty = true
+}
+
+// weightsChanged checks whether members or their weights differ from the ones used by the last distribution
+func (c *cHash[M]) weightsChanged() bool {
+	if len(c.weights) != len(c.members) {
+		return true
+	}
+	for id, m := range c.members {
+		if w, ok := c.weights[id]; !ok || w != c.weight(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// distribute distributes partitions after a change of the ring, it's postponed when Config.CoalesceWindow is set
+func (c *cHash[M]) distribute() {
+	// the staged table misses the change even when publishing it is postponed
+	c.staged = nil
+	if c.config.CoalesceWindow > 0 {
+		c.scheduleDistribute()
+		return
+	}
+	c.distributeNow()
+}
+
+// distributeNow distributes partitions regardless of Config.CoalesceWindow
+func (c *cHash[M]) distributeNow() {
+	_ = c.distributeCtx(context.Background())
+}
+
+// distributeCtx builds and publishes the partitions table, nothing is published if the context is done before the table is built
+func (c *cHash[M]) distributeCtx(ctx context.Context) error {
+	// removed and replaced members may change the smallest weight
+	c.normalizeCapacity(nil)
+	c.initStandby()
+	if c.config.Lazy {
+		c.distributeLazy()
+		return nil
+	}
+	partitions, err := c.buildPartitions(ctx, c.config.ReplicationFactor)
+	if err != nil {
+		return err
+	}
+	c.violations = nil
+	if c.config.MaxReplicaChangePerPartition > 0 {
+		c.violations = c.limitReplicaChanges(c.snapshot.Load().table(), partitions)
+	}
+	var nsPartitions = make([][][]M, len(c.namespaces))
+	for i, ns := range c.namespaces {
+		if nsPartitions[i], err = c.buildPartitions(ctx, ns.rf); err != nil {
+			return err
+		}
+	}
+	c.publish(partitions)
+	for i, ns := range c.namespaces {
+		ns.snapshot.Store(c.newSnapshot(nsPartitions[i]))
+	}
+	c.distributed()
+	return nil
+}
+
+// distributeLazy publishes snapshots computing partitions on demand
+func (c *cHash[M]) distributeLazy() {
+	c.initZones()
+	c.publishLazy(c.lazyPartitions(c.config.ReplicationFactor))
+	for _, ns := range c.namespaces {
+		ns.snapshot.Store(c.newLazySnapshot(c.lazyPartitions(ns.rf)))
+	}
+	c.distributed()
+}
+
+// distributed remembers weights used by the distribution, see DistributeIfChanged, and drops the postponed distribution
+func (c *cHash[M]) distributed() {
+	c.stopCoalescing()
+	c.weights = make(map[string]float64, len(c.members))
+	for id, m := range c.members {
+		c.weights[id] = c.weight(m)
+	}
+	c.dirty = false
+}
+
+// ctxCheckInterval is how many partitions are filled between context checks
+const ctxCheckInterval = 256
+
+// buildPartitions fills a new partitions table placing rf members to every partition when there are enough members
+func (c *cHash[M]) buildPartitions(ctx context.Context, rf int) ([][]M, error) {
+	var partitions = make([][]M, len(c.partitionHashes))
+	if c.placeableCount() == 0 {
+		return partitions, nil
+	}
+	rf = c.limitReplicationFactor(rf)
+	// always fill a new table: slices returned by GetMembers are shared with callers and must stay unchanged
+	var table = make([]M, len(c.partitionHashes)*rf)
+	for i := range partitions {
+		partitions[i] = table[i*rf : (i+1)*rf : (i+1)*rf]
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.config.Strategy != nil {
+		c.assign(partitions, rf)
+		return partitions, nil
+	}
+
+	// float sums depend on the order, so members are iterated by id and every process computes the same pieces
+	var ids = c.sortedIds()
+	var totalWeight float64
+	for _, id := range ids {
+		if c.isPlaceable(id) {
+			totalWeight += c.weight(c.members[id])
+		}
+	}
+	c.piecesPerMember = map[string]int{}
+	c.ownedPieces = map[string]int{}
+	var slots = float64(c.config.PartitionCount) * float64(rf)
+	var pieces = func(m M) int {
+		if c.config.LoadFactor > 0 {
+			return int(math.Ceil(slots * c.weight(m) / totalWeight * c.config.LoadFactor))
+		}
+		return int(slots/(totalWeight/c.weight(m)) + c.overflowTolerance())
+	}
+	if max := c.config.MaxPartitionsPerMember; max > 0 {
+		// capped members give their excess to others, so pieces are still enough for all slots when it's possible
+		for capped := true; capped; {
+			capped = false
+			for _, id := range ids {
+				if _, ok := c.piecesPerMember[id]; ok || !c.isPlaceable(id) {
+					continue
+				}
+				if m := c.members[id]; pieces(m) > max {
+					c.piecesPerMember[id] = max
+					slots -= float64(max)
+					totalWeight -= c.weight(m)
+					capped = true
+				}
+			}
+		}
+	}
+	for _, id := range ids {
+		if _, ok := c.piecesPerMember[id]; ok || !c.isPlaceable(id) {
+			continue
+		}
+		c.piecesPerMember[id] = pieces(c.members[id])
+	}
+
+	c.initZones()
+	var buf, zoneBuf = make([]string, rf), make([]string, rf)
+	// ring loses virtual members of capped members, so walks don't pass them over and over
+	var ring, capped = c.membersSet, 0
+	positions, closest := c.positions(rf)
+	for i, idx := range positions {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if ring.Len() != c.membersSet.Len() {
+			if ring.Len() == 0 {
+				partitions[i] = partitions[i][:0]
+				continue
+			}
+			idx = ring.search(c.partitionHashes[i])
+		}
+		var found = rf
+		if closest == nil || !c.takeClosest(closest[i*rf:(i+1)*rf], partitions[i]) {
+			found = c.fillClosest(ring, idx, partitions[i], buf, zoneBuf)
+		}
+		if found < rf {
+			partitions[i] = partitions[i][:found]
+		}
+		if c.config.MaxPartitionsPerMember > 0 {
+			for _, m := range partitions[i] {
+				if c.isCapped(m.Id()) {
+					capped++
+				}
+			}
+			if capped != 0 {
+				ring = c.uncapped(ring)
+				capped = 0
+			}
+		}
+	}
+	return partitions, nil
+}
+
+// uncapped returns a copy of the ring without virtual members of capped members
+func (c *cHash[M]) uncapped(ring members[M]) members[M] {
+	return ring.clone().retain(func(hash uint64, ref int32) bool {
+		return !c.isCapped(ring.ids[ref])
+	})
+}
+
+// lock takes the write lock
+func (c *cHash[M]) lock() {
+	c.mu.Lock()
+	c.locked = c.snapshot.Load()
+}
+
+// unlock releases the write lock and calls OnRebalance when partitions were changed while the lock was held
+func (c *cHash[M]) unlock() {
+	c.refreshPins()
+	var before, after, onRebalance = c.locked, c.snapshot.Load(), c.config.OnRebalance
+	c.locked = nil
+	if before != after {
+		// the staged table was computed for members of the previous table
+		c.staged = nil
+	}
+	c.mu.Unlock()
+	if before == after {
+		return
+	}
+	c.watchers.notify(after.version)
+	if onRebalance == nil {
+		return
+	}
+	if changed := changedPartitions(before.table(), after.table()); len(changed) != 0 {
+		onRebalance(changed)
+	}
+}
+
+// changedPartitions returns ids of new partitions with other members than old ones
+func changedPartitions[M Member](old, new [][]M) (changed []int) {
+	for i, ms := range new {
+		if i >= len(old) || !slices.EqualFunc(old[i], ms, func(a, b M) bool {
+			return a.Id() == b.Id()
+		}) {
+			changed = append(changed, i)
+		}
+	}
+	return
+}
+
+// publish replaces the snapshot used by readers, the replaced one is kept as the previous snapshot
+func (c *cHash[M]) publish(partitions [][]M) {
+	c.publishSnapshot(c.newSnapshot(partitions))
+}
+
+// publishLazy publishes the snapshot of lazily computed partitions
+func (c *cHash[M]) publishLazy(lp *lazyPartitions[M]) {
+	c.publishSnapshot(c.newLazySnapshot(lp))
+}
+
+func (c *cHash[M]) publishSnapshot(s *snapshot[M]) {
+	if prev := c.snapshot.Load(); prev != nil {
+		previous := *prev
+		// the key cache keeps partitions of the current generation only
+		previous.previous, previous.keyCache = nil, nil
+		s.previous = &previous
+	}
+	c.snapshot.Store(s)
+}
+
+// newSnapshot creates a snapshot of the partitions table mapping keys by the current key hasher
+func (c *cHash[M]) newSnapshot(partitions [][]M) *snapshot[M] {
+	s := &snapshot[M]{partitions: partitions, hasher: c.keyHasher, keyGen: c.keyGen, keyCache: c.keyCache}
+	if prev := c.snapshot.Load(); prev != nil {
+		s.version = prev.version + 1
+	}
+	if n := uint64(len(partitions)); n&(n-1) == 0 {
+		s.mask = n - 1
+	}
+	s.flat, s.stride = flatten(partitions)
+	return s
+}
+
+// newLazySnapshot creates a snapshot computing partitions by lp
+func (c *cHash[M]) newLazySnapshot(lp *lazyPartitions[M]) *snapshot[M] {
+	s := c.newSnapshot(make([][]M, len(lp.cells)))
+	s.lazy = lp
+	return s
+}
+
+// flatten copies members of partitions having the same count of members to one slice and returns it with the count
+// Partitions are replaced by parts of the slice, so the table must not be shared yet
+func flatten[M Member](partitions [][]M) ([]M, int) {
+	if len(partitions) == 0 || len(partitions[0]) == 0 {
+		return nil, 0
+	}
+	stride := len(partitions[0])
+	for _, ms := range partitions {
+		if len(ms) != stride {
+			return nil, 0
+		}
+	}
+	flat := make([]M, 0, len(partitions)*stride)
+	for i, ms := range partitions {
+		flat = append(flat, ms...)
+		partitions[i] = flat[i*stride : (i+1)*stride : (i+1)*stride]
+	}
+	return flat, stride
+}
+
+// assign fills partitions using the configured Strategy
+func (c *cHash[M]) assign(partitions [][]M, rf int) {
+	var ids = c.sortedIds()
+	var idx int
+	for _, id := range ids {
+		if c.isPlaceable(id) {
+			ids[idx] = id
+			idx++
+		}
+	}
+	ids = ids[:idx]
+	var members = make([]StrategyMember, len(ids))
+	for i, id := range ids {
+		members[i] = StrategyMember{Id: id, Weight: c.weight(c.members[id])}
+	}
+	for i, idxs := range c.config.Strategy.Assign(members, c.partitionHashes, rf) {
+		for j, idx := range idxs {
+			partitions[i][j] = c.members[ids[idx]]
+		}
+	}
+}
+
+// effectiveReplicationFactor returns the replication factor limited by count of members that can own partitions
+func (c *cHash[M]) effectiveReplicationFactor() int {
+	return c.limitReplicationFactor(c.config.ReplicationFactor)
+}
+
+// limitReplicationFactor limits rf by count of members that can own partitions
+func (c *cHash[M]) limitReplicationFactor(rf int) int {
+	if n := c.placeableCount(); n < rf {
+		rf = n
+	}
+	return rf
+}
+
+// overflowTolerance returns the configured overflow tolerance or the default one
+func (c *cHash[M]) overflowTolerance() float64 {
+	if c.config.OverflowTolerance == 0 {
+		return defaultOverflowTolerance
+	}
+	return c.config.OverflowTolerance
+}
+
+// checkReplication returns ErrInsufficientMembers when full replication is required, but there would be less than rf members owning partitions
+// namespaces need their own replication factors too
+// standby members replace missing ones, so they count as available
+func (c *cHash[M]) checkReplication(available, rf int) error {
+	if c.config.RequireFullReplication && available < c.requiredReplication(rf) {
+		return ErrInsufficientMembers
+	}
+	return nil
+}
+
+// requiredReplication returns the greatest of rf and replication factors of namespaces
+func (c *cHash[M]) requiredReplication(rf int) int {
+	for _, ns := range c.namespaces {
+		if ns.rf > rf {
+			rf = ns.rf
+		}
+	}
+	return rf
+}
+
+// initStandby picks standby members owning partitions instead of missing members
+func (c *cHash[M]) initStandby() {
+	c.spares, c.idleStandby = nil, 0
+	var standby = make([]string, 0, len(c.standby))
+	for id := range c.standby {
+		if _, drained := c.drained[id]; !drained {
+			standby = append(standby, id)
+		}
+	}
+	sort.Strings(standby)
+	if missing := c.requiredReplication(c.config.ReplicationFactor) - (c.availableCount() - len(standby)); missing > 0 {
+		if missing > len(standby) {
+			missing = len(standby)
+		}
+		c.spares = make(map[string]struct{}, missing)
+		for _, id := range standby[:missing] {
+			c.spares[id] = struct{}{}
+		}
+		standby = standby[missing:]
+	}
+	c.idleStandby = len(standby)
+}
+
+// isPlaceable checks whether the member can own partitions
+func (c *cHash[M]) isPlaceable(id string) bool {
+	if _, drained := c.drained[id]; drained {
+		return false
+	}
+	if _, standby := c.standby[id]; standby {
+		_, spare := c.spares[id]
+		return spare
+	}
+	return true
+}
+
+// placeableCount returns count of members that can own partitions
+func (c *cHash[M]) placeableCount() int {
+	return c.availableCount() - c.idleStandby
+}
+
+// availableCount returns count of members that aren't drained, standby members are counted too
+func (c *cHash[M]) availableCount() int {
+	return len(c.members) - len(c.drained)
+}
+
+// parallelWorkers returns how many goroutines walk the ring with Config.Parallel
+var parallelWorkers = runtime.NumCPU
+
+// positions returns indexes of the closest virtual members for every partition hash
+// with Config.Parallel it also walks the ring for every partition concurrently, closest keeps indexes of the rf members found ignoring pieces
+func (c *cHash[M]) positions(rf int) (positions []int, closest []int32) {
+	positions = make([]int, len(c.partitionHashes))
+	workers := parallelWorkers()
+	if c.config.Parallel && workers > 1 {
+		closest = make([]int32, len(positions)*rf)
+	}
+	var search = func(from, to int) {
+		var buf, zoneBuf = make([]string, rf), make([]string, rf)
+		for i := from; i < to; i++ {
+			positions[i] = c.membersSet.search(c.partitionHashes[i])
+			if closest != nil {
+				c.walkClosest(positions[i], closest[i*rf:(i+1)*rf], buf, zoneBuf)
+			}
+		}
+	}
+	if closest == nil {
+		search(0, len(positions))
+		return
+	}
+	var wg sync.WaitGroup
+	step := (len(positions) + workers - 1) / workers
+	for from := 0; from < len(positions); from += step {
+		to := from + step
+		if to > len(positions) {
+			to = len(positions)
+		}
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			search(from, to)
+		}(from, to)
+	}
+	wg.Wait()
+	return
+}
+
+// walkClosest finds distinct members following idx on the ring like fillClosest does when no member is out of pieces
+// the first index is -1 when the walk isn't that simple, fillClosest has to find members then
+func (c *cHash[M]) walkClosest(idx int, found []int32, buf, zoneBuf []string) {
+	var m = c.membersSet
+	var placeable = c.placeableCount()
+	var foundId, usedZones = buf[:0], zoneBuf[:0]
+	if placeable < len(found) {
+		found[0] = -1
+		return
+	}
+	for idle := 0; len(foundId) < len(found); idx++ {
+		if idle == m.Len() {
+			found[0] = -1
+			return
+		}
+		if idx == m.Len() {
+			idx = 0
+		}
+		idle++
+		id := m.id(idx)
+		if placeable != len(c.members) && !c.isPlaceable(id) {
+			continue
+		}
+		if slices.Contains(foundId, id) || len(usedZones) < c.zoneCount && slices.Contains(usedZones, c.zones[id]) {
+			continue
+		}
+		found[len(foundId)] = int32(idx)
+		foundId = append(foundId, id)
+		if c.zones != nil && !slices.Contains(usedZones, c.zones[id]) {
+			usedZones = append(usedZones, c.zones[id])
+		}
+		idle = 0
+	}
+}
+
+// takeClosest fills ms with members found by walkClosest if all of them have pieces left, fillClosest finds the same members then
+func (c *cHash[M]) takeClosest(found []int32, ms []M) bool {
+	if found[0] < 0 {
+		return false
+	}
+	for _, idx := range found {
+		if id := c.membersSet.id(int(idx)); c.piecesPerMember[id] < 1 || c.isCapped(id) {
+			return false
+		}
+	}
+	for i, idx := range found {
+		id := c.membersSet.id(int(idx))
+		c.piecesPerMember[id]--
+		c.ownedPieces[id]++
+		ms[i] = c.membersSet.member(int(idx))
+	}
+	return true
+}
+
+// fillClosest fills ms with distinct members following idx on the ring and returns how many members were found
+// it finds less members only when ms is longer than count of members that can own partitions
+func (c *cHash[M]) fillClosest(m members[M], idx int, ms []M, buf, zoneBuf []string) int {
+	var found int
+	var maxOverflow int
+	var foundId = buf[:0]
+	var usedZones = zoneBuf[:0]
+
+	var isAlreadyFound = func(id string) bool {
+		if slices.Contains(foundId, id) {
+			return true
+		}
+		// while there are unused zones, members of used zones are skipped the same way as already found ones
+		return len(usedZones) < c.zoneCount && slices.Contains(usedZones, c.zones[id])
+	}
+	// with LoadFactor members can't go over their pieces until the whole ring was passed without a match
+	var bounded = c.config.LoadFactor > 0
+	var steps, laps int
+	// idle counts steps since the last match, a whole idle lap means no one matches at the current limit
+	var idle int
+	// a member can't be more than all partitions slots over its pieces, when pieces are below that any member is forced to match
+	var floor = len(c.partitionHashes)*len(ms) + 2
+	var force bool
+	var placeable = c.placeableCount()
+	for found < len(ms) && found < placeable {
+		if idx == m.Len() {
+			idx = 0
+		}
+		if bounded && steps%m.Len() == 0 && !force {
+			if steps > 0 {
+				laps++
+			}
+			// don't walk the whole ring when no one can match anyway
+			maxPieces, ok := c.maxPieces(isAlreadyFound)
+			if !ok {
+				break
+			}
+			if maxPieces <= -laps {
+				if maxPieces < -floor {
+					force = true
+				} else {
+					laps = 1 - maxPieces
+				}
+			}
+		}
+		if idle == m.Len() {
+			if force {
+				break
+			}
+			maxPieces, ok := c.maxPieces(isAlreadyFound)
+			if !ok {
+				break
+			}
+			// let the member with the most pieces match during the next lap
+			if bounded || maxPieces < -floor {
+				force = true
+			} else {
+				maxOverflow = 1 - maxPieces
+			}
+			idle = 0
+		}
+		steps++
+		idle++
+		if placeable != len(c.members) && !c.isPlaceable(m.id(idx)) {
+			idx++
+			continue
+		}
+		if isAlreadyFound(m.id(idx)) {
+			maxOverflow++
+			idx++
+			continue
+		}
+		if c.isCapped(m.id(idx)) {
+			// the member is skipped like an already found one, but it doesn't let others overflow
+			idx++
+			continue
+		}
+		var limit = -maxOverflow
+		if bounded {
+			limit = -laps
+		}
+		if force || c.piecesPerMember[m.id(idx)] > limit {
+			c.piecesPerMember[m.id(idx)]--
+			c.ownedPieces[m.id(idx)]++
+			ms[found] = m.member(idx)
+			foundId = append(foundId, m.id(idx))
+			if c.zones != nil && !slices.Contains(usedZones, c.zones[m.id(idx)]) {
+				usedZones = append(usedZones, c.zones[m.id(idx)])
+			}
+			found++
+			idle = 0
+		}
+		idx++
+	}
+	return found
+}
+
+// maxPieces returns the most pieces left among members except the skipped and capped ones, false when all members are skipped
+func (c *cHash[M]) maxPieces(skip func(id string) bool) (max int, ok bool) {
+	for id, p := range c.piecesPerMember {
+		if (!ok || p > max) && !skip(id) && !c.isCapped(id) {
+			max, ok = p, true
+		}
+	}
+	return
+}
+
+// isCapped checks whether the member owns Config.MaxPartitionsPerMember partitions already
+func (c *cHash[M]) isCapped(id string) bool {
+	return c.config.MaxPartitionsPerMember > 0 && c.ownedPieces[id] >= c.config.MaxPartitionsPerMember
+}
+
+// initZones collects zones of members implementing Zoned, a member without zone is the only member of its own zone
+func (c *cHash[M]) initZones() {
+	c.zones, c.zoneCount = nil, 0
+	for _, m := range c.members {
+		if _, ok := any(m).(Zoned); ok {
+			c.zones = make(map[string]string, len(c.members))
+			break
+		}
+	}
+	if c.zones == nil {
+		return
+	}
+	var zones = make(map[string]struct{})
+	for id, m := range c.members {
+		if !c.isPlaceable(id) {
+			continue
+		}
+		zone := id
+		if zm, ok := any(m).(Zoned); ok {
+			zone = zm.Zone()
+		}
+		c.zones[id] = zone
+		zones[zone] = struct{}{}
+	}
+	c.zoneCount = len(zones)
+}
+
+// members is the hash ring of virtual members
+// hashes are kept apart from members, so the search and the walk in fillClosest scan tight slices
+type members[M Member] struct {
+	// hashes of virtual members sorted by hash and then by member id
+	hashes []uint64
+	// refs are indexes of virtual members in the table, refs[i] belongs to hashes[i]
+	refs []int32
+	// table contains every member of the ring once
+	table []M
+	// ids are ids of table members
+	ids []string
+}
+
+func (m members[M]) Len() int {
+	return len(m.hashes)
+}
+
+func (m members[M]) Less(i, j int) bool {
+	if m.hashes[i] == m.hashes[j] {
+		return m.id(i) < m.id(j)
+	} else {
+		return m.hashes[i] < m.hashes[j]
+	}
+}
+
+func (m members[M]) Swap(i, j int) {
+	m.hashes[i], m.hashes[j] = m.hashes[j], m.hashes[i]
+	m.refs[i], m.refs[j] = m.refs[j], m.refs[i]
+}
+
+// id returns id of the i-th virtual member
+func (m members[M]) id(i int) string {
+	return m.ids[m.refs[i]]
+}
+
+// member returns the member of the i-th virtual member
+func (m members[M]) member(i int) M {
+	return m.table[m.refs[i]]
+}
+
+// search returns index of the first virtual member with hash >= h
+func (m members[M]) search(h uint64) int {
+	return sort.Search(len(m.hashes), func(i int) bool {
+		return m.hashes[i] >= h
+	})
+}
+
+// addMember adds a new member to the table and returns its ref
+func (m *members[M]) addMember(member M) int32 {
+	m.table = append(m.table, member)
+	m.ids = append(m.ids, member.Id())
+	return int32(len(m.table) - 1)
+}
+
+// ref returns ref of the member with given id or -1
+func (m members[M]) ref(id string) int32 {
+	return int32(slices.Index(m.ids, id))
+}
+
+// add appends a virtual member, the set must be sorted after adding
+func (m *members[M]) add(hash uint64, ref int32) {
+	m.hashes = append(m.hashes, hash)
+	m.refs = append(m.refs, ref)
+}
+
+// retain keeps only virtual members matching the keep func, the order is preserved
+func (m members[M]) retain(keep func(hash uint64, ref int32) bool) members[M] {
+	var idx int
+	for i, hash := range m.hashes {
+		if keep(hash, m.refs[i]) {
+			m.hashes[idx], m.refs[idx] = hash, m.refs[i]
+			idx++
+		}
+	}
+	m.hashes, m.refs = m.hashes[:idx], m.refs[:idx]
+	return m
+}
+
+// remove removes members with given ids and their virtual members
+func (m members[M]) remove(ids ...string) members[M] {
+	var remap = make([]int32, len(m.table))
+	var n int
+	for i, id := range m.ids {
+		if slices.Contains(ids, id) {
+			remap[i] = -1
+			continue
+		}
+		m.table[n], m.ids[n] = m.table[i], id
+		remap[i] = int32(n)
+		n++
+	}
+	m.table, m.ids = m.truncateTable(n)
+	return m.retain(func(hash uint64, ref int32) bool {
+		return remap[ref] >= 0
+	}).remapRefs(remap)
+}
+
+// remapRefs replaces every ref with remap[ref]
+func (m members[M]) remapRefs(remap []int32) members[M] {
+	for i, ref := range m.refs {
+		m.refs[i] = remap[ref]
+	}
+	return m
+}
+
+// truncateTable cuts the table to n members releasing references to the cut ones
+func (m members[M]) truncateTable(n int) ([]M, []string) {
+	var empty M
+	for i := n; i < len(m.table); i++ {
+		m.table[i], m.ids[i] = empty, ""
+	}
+	return m.table[:n], m.ids[:n]
+}
+
+// reset removes all members keeping allocated arrays
+func (m members[M]) reset() members[M] {
+	m.table, m.ids = m.truncateTable(0)
+	return members[M]{hashes: m.hashes[:0], refs: m.refs[:0], table: m.table, ids: m.ids}
+}
+
+// compactRatio is how many times capacity of the set arrays may exceed their length before compact reallocates them
+const compactRatio = 4
+
+// compact reallocates arrays of the set when most of their capacity is unused, e.g. after removal of most members
+func (m members[M]) compact() members[M] {
+	if cap(m.hashes) > compactRatio*len(m.hashes) || cap(m.table) > compactRatio*len(m.table) {
+		return m.clone()
+	}
+	return m
+}
+
+// clone returns a deep copy of the set
+func (m members[M]) clone() members[M] {
+	return members[M]{
+		hashes: slices.Clone(m.hashes),
+		refs:   slices.Clone(m.refs),
+		table:  slices.Clone(m.table),
+		ids:    slices.Clone(m.ids),
+	}
+}
+
+// merge merges two sorted sets into a new sorted set
+// the other set must be built over the same table, possibly grown, its table is used by the result
+func (m members[M]) merge(other members[M]) members[M] {
+	if m.Len() == 0 {
+		return other
+	}
+	var result = members[M]{
+		hashes: make([]uint64, 0, m.Len()+other.Len()),
+		refs:   make([]int32, 0, m.Len()+other.Len()),
+		table:  other.table,
+		ids:    other.ids,
+	}
+	var i, j int
+	for i < m.Len() && j < other.Len() {
+		if other.hashes[j] < m.hashes[i] || (other.hashes[j] == m.hashes[i] && other.id(j) < m.id(i)) {
+			result.add(other.hashes[j], other.refs[j])
+			j++
+		} else {
+			result.add(m.hashes[i], m.refs[i])
+			i++
+		}
+	}
+	result.hashes = append(append(result.hashes, m.hashes[i:]...), other.hashes[j:]...)
+	result.refs = append(append(result.refs, m.refs[i:]...), other.refs[j:]...)
+	return result
 }
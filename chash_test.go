@@ -1,11 +1,19 @@
 package chash
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"github.com/cespare/xxhash"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+	"hash/fnv"
+	"math"
 	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -27,6 +35,47 @@ func (t testMember) String() string {
 	return t.id
 }
 
+type fnvHasher struct{}
+
+func (fnvHasher) Sum64(data []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return h.Sum64()
+}
+
+// bytesHasher hashes only bytes, so strings are converted for it
+type bytesHasher struct{}
+
+func (bytesHasher) Sum64(data []byte) uint64 {
+	return xxhash.Sum64(data)
+}
+
+type zonedMember struct {
+	testMember
+	zone string
+}
+
+func (z zonedMember) Zone() string {
+	return z.zone
+}
+
+type weightedMember struct {
+	testMember
+	weight float64
+}
+
+func (w weightedMember) Weight() float64 {
+	return w.weight
+}
+
+type standbyMember struct {
+	testMember
+}
+
+func (standbyMember) Standby() bool {
+	return true
+}
+
 func TestNew(t *testing.T) {
 	t.Run("invalid part count", func(t *testing.T) {
 		_, err := New(Config{PartitionCount: 0})
@@ -38,6 +87,21 @@ func TestNew(t *testing.T) {
 	})
 }
 
+type constHasher struct{}
+
+func (constHasher) Sum64([]byte) uint64 {
+	return 42
+}
+
+func TestNew_DegenerateHasher(t *testing.T) {
+	_, err := NewWithOptions(WithPartitionCount(10), WithHasher(constHasher{}))
+	assert.EqualError(t, err, "hasher returns the same hash for distinct inputs")
+	_, err = NewWithOptions(WithPartitionCount(10), WithKeyHasher(constHasher{}))
+	assert.EqualError(t, err, "key hasher returns the same hash for distinct inputs")
+	_, err = NewWithOptions(WithPartitionCount(10), WithHasher(fnvHasher{}))
+	assert.NoError(t, err)
+}
+
 func TestCHash_AddMembers(t *testing.T) {
 	t.Run("common add", func(t *testing.T) {
 		pc := 100
@@ -55,6 +119,8 @@ func TestCHash_AddMembers(t *testing.T) {
 		})
 		require.NoError(t, err)
 		assert.Equal(t, ErrInvalidCapacity, h.AddMembers(testMember{id: "1", cap: 0}))
+		assert.Equal(t, ErrInvalidCapacity, h.AddMembers(testMember{id: "1", cap: math.NaN()}))
+		assert.Equal(t, ErrInvalidCapacity, h.AddMembers(testMember{id: "1", cap: math.Inf(1)}))
 	})
 	t.Run("member exists", func(t *testing.T) {
 		h, err := New(Config{
@@ -64,6 +130,505 @@ func TestCHash_AddMembers(t *testing.T) {
 		assert.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}))
 		assert.Equal(t, ErrMemberExists, h.AddMembers(testMember{id: "1", cap: 1}))
 	})
+	t.Run("tiny capacity", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 0.00001}))
+		var found bool
+		for i := 0; i < h.PartitionCount(); i++ {
+			ms, err := h.GetPartitionMembers(i)
+			require.NoError(t, err)
+			for _, m := range ms {
+				if m.Id() == "2" {
+					found = true
+				}
+			}
+		}
+		assert.True(t, found)
+	})
+	t.Run("prefixed ids", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount: 100,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "a", cap: 1}, testMember{id: "a1", cap: 1}))
+		var hashes = map[string]map[uint64]bool{"a": {}, "a1": {}}
+		ms := h.(*cHash[Member]).membersSet
+		for i, hash := range ms.hashes {
+			hashes[ms.id(i)][hash] = true
+		}
+		for hash := range hashes["a"] {
+			assert.False(t, hashes["a1"][hash])
+		}
+	})
+}
+
+func TestCHash_Seed(t *testing.T) {
+	newRing := func(seed uint64) CHash {
+		h, err := NewWithOptions(WithPartitionCount(100), WithReplicationFactor(2), WithSeed(seed))
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+		return h
+	}
+	h1, h1Again, h2 := newRing(1), newRing(1), newRing(2)
+	var differentPartitions int
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key", i)
+		assert.Equal(t, h1.GetPartition(key), h1Again.GetPartition(key))
+		assert.Equal(t, h1.GetMembers(key), h1Again.GetMembers(key))
+		if h1.GetPartition(key) != h2.GetPartition(key) {
+			differentPartitions++
+		}
+	}
+	assert.Greater(t, differentPartitions, 80)
+	assert.NotEqual(t, h1.Partitions(), h2.Partitions())
+}
+
+func TestCHash_NormalizeCapacity(t *testing.T) {
+	newRing := func(t *testing.T, normalize bool, members ...Member) CHash {
+		h, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+			MultiplyFactor:    100,
+			NormalizeCapacity: normalize,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(members...))
+		return h
+	}
+	virtualCount := func(h CHash) int {
+		return h.(*cHash[Member]).membersSet.Len()
+	}
+	raw := []Member{testMember{id: "a", cap: 1e12}, testMember{id: "b", cap: 2e12}, testMember{id: "c", cap: 1.5e12}}
+	h := newRing(t, true, raw...)
+	expected := newRing(t, false, testMember{id: "a", cap: 1}, testMember{id: "b", cap: 2}, testMember{id: "c", cap: 1.5})
+	assert.Equal(t, 450, virtualCount(h))
+	assert.True(t, expected.Equal(h))
+	assert.Equal(t, expected.LoadDistribution(), h.LoadDistribution())
+
+	t.Run("smaller member", func(t *testing.T) {
+		h := h.Clone()
+		require.NoError(t, h.AddMembers(testMember{id: "d", cap: 0.5e12}))
+		assert.Equal(t, 1000, virtualCount(h))
+		assert.True(t, newRing(t, true, append(raw, testMember{id: "d", cap: 0.5e12})...).Equal(h))
+	})
+	t.Run("removed member", func(t *testing.T) {
+		h := h.Clone()
+		require.NoError(t, h.RemoveMembers("a"))
+		assert.Equal(t, 233, virtualCount(h))
+		assert.True(t, newRing(t, true, raw[1:]...).Equal(h))
+	})
+	t.Run("update capacity", func(t *testing.T) {
+		h := h.Clone()
+		require.NoError(t, h.UpdateCapacity("a", 4e12))
+		assert.Equal(t, 499, virtualCount(h))
+		assert.True(t, newRing(t, true, testMember{id: "a", cap: 4e12}, raw[1], raw[2]).Equal(h))
+	})
+	t.Run("add members func", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 100, ReplicationFactor: 2, MultiplyFactor: 100, NormalizeCapacity: true})
+		require.NoError(t, err)
+		var i int
+		require.NoError(t, h2.AddMembersFunc(func() (Member, bool) {
+			if i == len(raw) {
+				return nil, false
+			}
+			i++
+			return raw[i-1], true
+		}))
+		assert.Equal(t, 450, virtualCount(h2))
+		assert.True(t, h.Equal(h2))
+	})
+	t.Run("marshal", func(t *testing.T) {
+		data, err := h.MarshalProto()
+		require.NoError(t, err)
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.UnmarshalProto(data))
+		assert.True(t, h.Equal(h2))
+	})
+}
+
+func TestCHash_UnsafeStringHash(t *testing.T) {
+	for _, seed := range []uint64{0, 42} {
+		t.Run(fmt.Sprint("seed ", seed), func(t *testing.T) {
+			var rings []CHash
+			for _, unsafeStrings := range []bool{false, true} {
+				h, err := New(Config{
+					PartitionCount:    100,
+					ReplicationFactor: 2,
+					Hasher:            bytesHasher{},
+					KeyHasher:         bytesHasher{},
+					Seed:              seed,
+					UnsafeStringHash:  unsafeStrings,
+				})
+				require.NoError(t, err)
+				require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+				rings = append(rings, h)
+			}
+			safe, fast := rings[0], rings[1]
+			assert.True(t, safe.Equal(fast))
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprint("k", i)
+				require.Equal(t, safe.GetPartition(key), fast.GetPartition(key))
+				require.Equal(t, safe.GetMembers(key), fast.GetMembers(key))
+				require.Equal(t, safe.KeyPosition(key), fast.KeyPosition(key))
+			}
+			var uuid = [16]byte{1, 2, 3}
+			assert.Equal(t, safe.GetPartitionUUID(uuid), fast.GetPartitionUUID(uuid))
+
+			key := strings.Repeat("k", 100)
+			assert.NotZero(t, testing.AllocsPerRun(100, func() {
+				safe.GetMembers(key)
+			}))
+			assert.Zero(t, testing.AllocsPerRun(100, func() {
+				fast.GetMembers(key)
+			}))
+		})
+	}
+}
+
+func TestCHash_KeyHasher(t *testing.T) {
+	h, err := NewWithOptions(WithPartitionCount(100), WithKeyHasher(fnvHasher{}))
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	def, err := NewWithOptions(WithPartitionCount(100))
+	require.NoError(t, err)
+	require.NoError(t, def.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+
+	// the ring is built by the default hasher
+	assert.Equal(t, def.Partitions(), h.Partitions())
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key", i)
+		expected := int(fnvHasher{}.Sum64([]byte(key)) % 100)
+		assert.Equal(t, expected, h.GetPartition(key))
+		assert.Equal(t, def.Partitions()[expected], h.GetMembers(key))
+	}
+}
+
+func TestCHash_PowerOfTwoPartitionCount(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount: 1024,
+	})
+	require.NoError(t, err)
+	s := h.(*cHash[Member]).snapshot.Load()
+	assert.Equal(t, uint64(1023), s.mask)
+	modulo := &snapshot[Member]{partitions: s.partitions, hasher: s.hasher}
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprint("key", i))
+		assert.Equal(t, modulo.partition(key), s.partition(key))
+	}
+
+	require.NoError(t, h.SetPartitionCount(1000))
+	assert.Equal(t, uint64(0), h.(*cHash[Member]).snapshot.Load().mask)
+}
+
+func TestCHash_OnRebalance(t *testing.T) {
+	var (
+		h     CHash
+		calls [][]int
+	)
+	h, err := NewWithOptions(WithPartitionCount(100), WithReplicationFactor(2), WithOnRebalance(func(changed []int) {
+		// the ring must be usable from the callback
+		assert.NotZero(t, h.MemberCount())
+		calls = append(calls, changed)
+	}))
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	require.Len(t, calls, 1)
+	assert.Len(t, calls[0], 100)
+
+	before := h.Partitions()
+	require.NoError(t, h.AddMembers(testMember{id: "3", cap: 1}))
+	require.Len(t, calls, 2)
+	var expected []int
+	for _, change := range Diff(before, h.Partitions()) {
+		expected = append(expected, change.PartitionId)
+	}
+	assert.NotEmpty(t, calls[1])
+	assert.Equal(t, expected, calls[1])
+
+	// nothing changed
+	h.Distribute()
+	assert.Len(t, calls, 2)
+	assert.Error(t, h.AddMembers(testMember{id: "3", cap: 1}))
+	assert.Len(t, calls, 2)
+}
+
+func TestCHash_GetMembersByHash(t *testing.T) {
+	for _, pc := range []uint64{100, 128} {
+		h, err := New(Config{
+			PartitionCount:    pc,
+			ReplicationFactor: 2,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint("key", i)
+			hash := xxhash.Sum64String(key)
+			assert.Equal(t, h.GetPartition(key), h.GetPartitionByHash(hash))
+			assert.Equal(t, h.GetMembers(key), h.GetMembersByHash(hash))
+		}
+	}
+}
+
+func TestCHash_Weighted(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    1000,
+		ReplicationFactor: 1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(
+		weightedMember{testMember: testMember{id: "1", cap: 1}, weight: 1},
+		weightedMember{testMember: testMember{id: "2", cap: 1}, weight: 3},
+	))
+	assert.InDelta(t, 250, len(h.PartitionsOwnedBy("1")), 5)
+	assert.InDelta(t, 750, len(h.PartitionsOwnedBy("2")), 5)
+
+	assert.Equal(t, ErrInvalidWeight, h.AddMembers(weightedMember{testMember: testMember{id: "3", cap: 1}, weight: 0}))
+	assert.Equal(t, ErrInvalidCapacity, h.AddMembers(weightedMember{testMember: testMember{id: "3", cap: 0}, weight: 1}))
+}
+
+func TestCHash_RequireFullReplication(t *testing.T) {
+	var members = []Member{testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}}
+	t.Run("degrade", func(t *testing.T) {
+		h, err := NewWithOptions(WithPartitionCount(10), WithReplicationFactor(3))
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(members[:2]...))
+		assert.Len(t, h.GetMembers("key"), 2)
+		require.NoError(t, h.AddMembers(members[2]))
+		require.NoError(t, h.RemoveMembers("1"))
+		assert.Len(t, h.GetMembers("key"), 2)
+	})
+	t.Run("require", func(t *testing.T) {
+		h, err := NewWithOptions(WithPartitionCount(10), WithReplicationFactor(3), WithRequireFullReplication(true))
+		require.NoError(t, err)
+		assert.Equal(t, ErrInsufficientMembers, h.AddMembers(members[:2]...))
+		assert.Equal(t, 0, h.MemberCount())
+		assert.Equal(t, ErrInsufficientMembers, h.Reconfigure(members[:1]))
+		assert.Equal(t, 0, h.MemberCount())
+
+		require.NoError(t, h.AddMembers(members...))
+		before := h.Partitions()
+		assert.Equal(t, ErrInsufficientMembers, h.RemoveMembers("1"))
+		assert.Equal(t, ErrInsufficientMembers, h.DrainMember("1"))
+		assert.Equal(t, ErrInsufficientMembers, h.SetReplicationFactor(4))
+		assert.Equal(t, 3, h.MemberCount())
+		assert.Equal(t, before, h.Partitions())
+		assert.Empty(t, h.UnderReplicatedPartitions())
+
+		require.NoError(t, h.AddMembers(testMember{id: "4", cap: 1}))
+		require.NoError(t, h.RemoveMembers("1"))
+		assert.Len(t, h.GetMembers("key"), 3)
+	})
+}
+
+func TestCHash_OverflowTolerance(t *testing.T) {
+	type result struct {
+		partitions [][]Member
+		moved      int
+		stats      BalanceStats
+	}
+	run := func(opts ...Option) result {
+		h, err := NewWithOptions(append([]Option{WithPartitionCount(1000), WithReplicationFactor(2)}, opts...)...)
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+		}
+		before := h.Partitions()
+		require.NoError(t, h.AddMembers(testMember{id: "n10", cap: 1}))
+		return result{partitions: h.Partitions(), moved: movedSlots(before, h.Partitions()), stats: h.BalanceStats()}
+	}
+	def, tight, loose := run(), run(WithOverflowTolerance(1)), run(WithOverflowTolerance(50))
+	assert.Equal(t, def.partitions, tight.partitions)
+	assert.Equal(t, def.partitions, run(WithOverflowTolerance(0)).partitions)
+	tightest := run(WithOverflowTolerance(math.SmallestNonzeroFloat64))
+	assert.LessOrEqual(t, tightest.stats.MaxPartitions, tight.stats.MaxPartitions)
+	for _, ms := range tightest.partitions {
+		assert.Len(t, ms, 2)
+	}
+	t.Logf("moved slots: tight %d, loose %d", tight.moved, loose.moved)
+	assert.Less(t, loose.moved, tight.moved)
+	assert.Greater(t, loose.stats.MaxPartitions, tight.stats.MaxPartitions)
+
+	_, err := NewWithOptions(WithPartitionCount(10), WithOverflowTolerance(-1))
+	assert.Error(t, err)
+}
+
+func TestCHash_FillClosestTerminates(t *testing.T) {
+	for _, lf := range []float64{0, 1.25} {
+		t.Run(fmt.Sprint(lf), func(t *testing.T) {
+			h, err := New(Config{
+				PartitionCount:    10,
+				ReplicationFactor: 2,
+				LoadFactor:        lf,
+			})
+			require.NoError(t, err)
+			require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+			c := h.(*cHash[Member])
+			// ask for more members than there are in the ring
+			var ms = make([]Member, 3)
+			found := c.fillClosest(c.membersSet, 0, ms, make([]string, 3), make([]string, 3))
+			assert.Equal(t, 2, found)
+			assert.ElementsMatch(t, []string{"1", "2"}, memberIds(ms[:found]))
+
+			// pieces are exhausted beyond any possible overflow, members are forced to match within a few laps
+			for _, pieces := range []int{-100, math.MinInt} {
+				for id := range c.piecesPerMember {
+					c.piecesPerMember[id] = pieces
+				}
+				found = c.fillClosest(c.membersSet, 0, ms[:2], make([]string, 2), make([]string, 2))
+				assert.Equal(t, 2, found)
+			}
+		})
+	}
+}
+
+func TestCHash_Clear(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	h.Clear()
+	assert.Equal(t, 0, h.MemberCount())
+	assert.Nil(t, h.GetMembers("x"))
+	assert.Zero(t, h.(*cHash[Member]).membersSet.Len())
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}))
+	assert.Len(t, h.GetMembers("x"), 1)
+}
+
+func TestCHash_Clone(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	before := h.Partitions()
+
+	clone := h.Clone()
+	assert.Equal(t, before, clone.Partitions())
+	require.NoError(t, clone.AddMembers(testMember{id: "3", cap: 1}))
+	require.NoError(t, clone.UpdateCapacity("1", 2))
+	assert.Equal(t, 3, clone.MemberCount())
+	assert.NotEqual(t, before, clone.Partitions())
+
+	assert.Equal(t, 2, h.MemberCount())
+	assert.Equal(t, before, h.Partitions())
+	assert.Equal(t, 4000, h.(*cHash[Member]).membersSet.Len())
+}
+
+func TestCHash_AddMembersAtomic(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}))
+	before := h.Partitions()
+	failing := weightedMember{testMember: testMember{id: "3", cap: 1}, weight: math.NaN()}
+	assert.Equal(t, ErrInvalidWeight, h.AddMembers(testMember{id: "2", cap: 1}, failing))
+	// bypass the validation to check that the ring isn't changed when building of virtual members fails
+	assert.Equal(t, ErrInvalidWeight, h.(*cHash[Member]).addMembers(testMember{id: "2", cap: 1}, failing))
+	assert.Equal(t, 1, h.MemberCount())
+	assert.False(t, h.ContainsMember("2"))
+	assert.Equal(t, 2000, h.(*cHash[Member]).membersSet.Len())
+	assert.Equal(t, before, h.Partitions())
+	require.NoError(t, h.AddMembers(testMember{id: "2", cap: 1}))
+	assert.Equal(t, 4000, h.(*cHash[Member]).membersSet.Len())
+}
+
+func TestCHash_AddMembersFunc(t *testing.T) {
+	c := Config{ReplicationFactor: 3, PartitionCount: 1000, MultiplyFactor: 100}
+	generator := func(count int, last Member) func() (Member, bool) {
+		var i int
+		return func() (Member, bool) {
+			i++
+			switch {
+			case i <= count:
+				return testMember{id: fmt.Sprint("n", i), cap: float64(i%4+1) / 2}, true
+			case i == count+1 && last != nil:
+				return last, true
+			}
+			return nil, false
+		}
+	}
+	t.Run("stream", func(t *testing.T) {
+		h1, err := New(c)
+		require.NoError(t, err)
+		h2, err := New(c)
+		require.NoError(t, err)
+		var ms []Member
+		for next := generator(500, nil); ; {
+			m, ok := next()
+			if !ok {
+				break
+			}
+			ms = append(ms, m)
+		}
+		require.NoError(t, h1.AddMembers(ms...))
+		require.NoError(t, h2.AddMembersFunc(generator(500, nil)))
+		assert.Equal(t, 500, h2.MemberCount())
+		assert.True(t, h1.Equal(h2))
+		assert.Equal(t, uint64(1), h2.Version())
+		require.NoError(t, h2.AddMembersFunc(generator(0, nil)))
+		assert.Equal(t, uint64(1), h2.Version())
+	})
+	t.Run("errors", func(t *testing.T) {
+		h, err := New(c)
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "existing", cap: 1}))
+		before := h.Partitions()
+		for _, v := range []struct {
+			last     Member
+			expected error
+		}{
+			{testMember{id: "n1", cap: 1}, ErrMemberExists},
+			{testMember{id: "existing", cap: 1}, ErrMemberExists},
+			{testMember{id: "invalid"}, ErrInvalidCapacity},
+			{weightedMember{testMember{id: "invalid", cap: 1}, -1}, ErrInvalidWeight},
+		} {
+			assert.Equal(t, v.expected, h.AddMembersFunc(generator(300, v.last)))
+			assert.Equal(t, 1, h.MemberCount())
+			assert.Equal(t, before, h.Partitions())
+			assert.Equal(t, 100, h.(*cHash[Member]).membersSet.Len())
+		}
+		require.NoError(t, h.AddMembersFunc(generator(10, nil)))
+		assert.Equal(t, 11, h.MemberCount())
+	})
+}
+
+func TestCHash_AddMembersDeferred(t *testing.T) {
+	c := Config{ReplicationFactor: 3, PartitionCount: 300, MultiplyFactor: 100}
+	h1, err := New(c)
+	require.NoError(t, err)
+	h2, err := New(c)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		m := testMember{id: fmt.Sprint("n", i), cap: float64(i%4+1) / 2}
+		require.NoError(t, h1.AddMembers(m))
+		require.NoError(t, h2.AddMembersDeferred(m))
+	}
+	assert.Equal(t, 0, h2.MemberCount())
+	assert.Nil(t, h2.GetMembers("key"))
+	assert.Equal(t, ErrMemberExists, h2.AddMembersDeferred(testMember{id: "n1", cap: 1}))
+	assert.Equal(t, ErrMemberExists, h2.AddMembers(testMember{id: "n1", cap: 1}))
+	assert.Equal(t, ErrInvalidCapacity, h2.AddMembersDeferred(testMember{id: "n20"}))
+
+	require.NoError(t, h2.Commit())
+	assert.Equal(t, 20, h2.MemberCount())
+	assert.Equal(t, h1.Partitions(), h2.Partitions())
+	require.NoError(t, h2.Commit())
+	assert.Equal(t, h1.Partitions(), h2.Partitions())
+
+	require.NoError(t, h2.AddMembersDeferred(testMember{id: "n20", cap: 1}))
+	require.NoError(t, h2.Reconfigure(h1.Members()))
+	require.NoError(t, h2.Commit())
+	assert.False(t, h2.ContainsMember("n20"))
 }
 
 func TestCHash_Reconfigure(t *testing.T) {
@@ -75,6 +640,20 @@ func TestCHash_Reconfigure(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, ErrInvalidCapacity, h.Reconfigure([]Member{&testMember{id: "1"}}))
 	})
+	t.Run("duplicate ids", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    10,
+			ReplicationFactor: 1,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "2", cap: 1}))
+		before := h.Partitions()
+		assert.Equal(t, ErrMemberExists, h.Reconfigure([]Member{testMember{id: "1", cap: 1}, testMember{id: "1", cap: 2}}))
+		assert.Equal(t, ErrMemberExists, h.AddMembers(testMember{id: "3", cap: 1}, testMember{id: "3", cap: 1}))
+		assert.Equal(t, []string{"2"}, memberIds(h.Members()))
+		assert.Equal(t, 2000, h.(*cHash[Member]).membersSet.Len())
+		assert.Equal(t, before, h.Partitions())
+	})
 	t.Run("reconfigure", func(t *testing.T) {
 		c := Config{ReplicationFactor: 3, PartitionCount: 100}
 		members := []Member{
@@ -102,6 +681,51 @@ func TestCHash_Reconfigure(t *testing.T) {
 	})
 }
 
+func TestCHash_DistributionFingerprint(t *testing.T) {
+	// fingerprints of the distribution, they must change only when the placement is changed on purpose
+	for lf, expected := range map[float64]uint64{0: 0xcdff382e3f48cab1, 1.25: 0x564088d05945110b} {
+		h, err := New(Config{PartitionCount: 1000, ReplicationFactor: 3, MultiplyFactor: 100, LoadFactor: lf})
+		require.NoError(t, err)
+		for i := 0; i < 30; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: float64(i%4+1) / 2}))
+		}
+		require.NoError(t, h.RemoveMembers("n3", "n17"))
+		require.NoError(t, h.UpdateCapacity("n5", 3))
+		require.NoError(t, h.UpdateCapacity("n6", 0.5))
+		d := xxhash.New()
+		for _, ms := range h.Partitions() {
+			for _, m := range ms {
+				_, _ = d.Write([]byte(m.Id()))
+				_, _ = d.Write([]byte{0})
+			}
+		}
+		assert.Equal(t, expected, d.Sum64(), lf)
+	}
+}
+
+func TestCHash_AddMembersIncremental(t *testing.T) {
+	c := Config{ReplicationFactor: 3, PartitionCount: 300, MultiplyFactor: 100}
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 10; n++ {
+		h1, err := New(c)
+		require.NoError(t, err)
+		var all []Member
+		for len(all) < 20 {
+			var batch []Member
+			for i := rnd.Intn(3) + 1; i > 0; i-- {
+				batch = append(batch, testMember{id: fmt.Sprint("n", len(all)+len(batch)), cap: float64(rnd.Intn(4)+1) / 2})
+			}
+			require.NoError(t, h1.AddMembers(batch...))
+			all = append(all, batch...)
+		}
+		h2, err := New(c)
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(all...))
+		assert.Equal(t, h2.(*cHash[Member]).membersSet, h1.(*cHash[Member]).membersSet)
+		assert.Equal(t, h2.(*cHash[Member]).snapshot.Load().partitions, h1.(*cHash[Member]).snapshot.Load().partitions)
+	}
+}
+
 func TestCHash_RemoveMembers(t *testing.T) {
 	t.Run("remove", func(t *testing.T) {
 		pc := 10
@@ -118,6 +742,21 @@ func TestCHash_RemoveMembers(t *testing.T) {
 		}
 		assert.NoError(t, h.RemoveMembers("3"))
 	})
+	t.Run("remove member", func(t *testing.T) {
+		var members = []Member{testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}}
+		h1, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h1.AddMembers(members...))
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(members...))
+
+		require.NoError(t, h1.RemoveMember(members[1]))
+		require.NoError(t, h2.RemoveMembers(members[1].Id()))
+		assert.Equal(t, h2.Members(), h1.Members())
+		assert.Equal(t, h2.Partitions(), h1.Partitions())
+		assert.Equal(t, ErrMemberNotExists, h1.RemoveMember(members[1]))
+	})
 	t.Run("err not exists", func(t *testing.T) {
 		h, err := New(Config{
 			PartitionCount: 10,
@@ -127,6 +766,39 @@ func TestCHash_RemoveMembers(t *testing.T) {
 		require.NoError(t, h.RemoveMembers("1"))
 		assert.Equal(t, ErrMemberNotExists, h.RemoveMembers("1"))
 	})
+	t.Run("compact", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount: 100,
+			MultiplyFactor: 10,
+		})
+		require.NoError(t, err)
+		var members, ids = make([]Member, 1000), make([]string, 0, 990)
+		for i := range members {
+			members[i] = testMember{id: fmt.Sprint(i), cap: 1}
+			if i >= 10 {
+				ids = append(ids, members[i].Id())
+			}
+		}
+		require.NoError(t, h.AddMembers(members...))
+		set := &h.(*cHash[Member]).membersSet
+		require.GreaterOrEqual(t, cap(set.hashes), 10000)
+
+		require.NoError(t, h.RemoveMembers(ids...))
+		assert.Equal(t, 100, set.Len())
+		assert.LessOrEqual(t, cap(set.hashes), compactRatio*set.Len())
+		assert.LessOrEqual(t, cap(set.table), compactRatio*len(set.table))
+		fresh, err := New(Config{
+			PartitionCount: 100,
+			MultiplyFactor: 10,
+		})
+		require.NoError(t, err)
+		require.NoError(t, fresh.AddMembers(members[:10]...))
+		assert.True(t, fresh.Equal(h))
+
+		require.NoError(t, h.Reconfigure(members))
+		require.NoError(t, h.Reconfigure(members[:1]))
+		assert.LessOrEqual(t, cap(set.hashes), compactRatio*set.Len())
+	})
 }
 
 func TestCapacity(t *testing.T) {
@@ -270,31 +942,1233 @@ func TestCHash_Distribute(t *testing.T) {
 	})
 }
 
-func TestCHash_PartitionCount(t *testing.T) {
+func TestCHash_DistributeIfChanged(t *testing.T) {
 	h, err := New(Config{
-		PartitionCount:    10,
-		ReplicationFactor: 3,
+		PartitionCount:    100,
+		ReplicationFactor: 2,
 	})
 	require.NoError(t, err)
-	assert.Equal(t, 10, h.PartitionCount())
-}
+	m := &testMember{id: "1", cap: 1}
+	require.NoError(t, h.AddMembers(m, &testMember{id: "2", cap: 1}, &testMember{id: "3", cap: 1}))
+	version := h.Version()
 
-func BenchmarkCHash_GetMembers(b *testing.B) {
-	h, err := New(Config{
-		PartitionCount:    3000,
-		ReplicationFactor: 3,
+	t.Run("unchanged", func(t *testing.T) {
+		assert.False(t, h.DistributeIfChanged())
+		assert.False(t, h.DistributeIfChanged())
+		assert.Equal(t, version, h.Version())
 	})
-	require.NoError(b, err)
-	for i := 0; i < 30; i++ {
-		h.AddMembers(&testMember{
-			id:  fmt.Sprint("n", i),
-			cap: 1,
+	t.Run("capacity", func(t *testing.T) {
+		m.cap = 3
+		assert.True(t, h.DistributeIfChanged())
+		assert.False(t, h.DistributeIfChanged())
+		assert.Equal(t, version+1, h.Version())
+		assert.Greater(t, len(h.PartitionsOwnedBy("1")), len(h.PartitionsOwnedBy("2")))
+	})
+	t.Run("mark dirty", func(t *testing.T) {
+		version := h.Version()
+		h.MarkDirty()
+		assert.True(t, h.DistributeIfChanged())
+		assert.False(t, h.DistributeIfChanged())
+		assert.Equal(t, version+1, h.Version())
+	})
+	t.Run("distributed by changes", func(t *testing.T) {
+		h.MarkDirty()
+		require.NoError(t, h.UpdateCapacity("2", 2))
+		assert.False(t, h.DistributeIfChanged())
+	})
+}
+
+func TestCHash_MaxPartitionsPerMember(t *testing.T) {
+	assert.Error(t, Config{PartitionCount: 10, ReplicationFactor: 1, MultiplyFactor: 1, MaxPartitionsPerMember: -1}.Validate())
+	for _, lf := range []float64{0, 1.25} {
+		t.Run(fmt.Sprint("load factor ", lf), func(t *testing.T) {
+			h, err := New(Config{
+				PartitionCount:         1000,
+				ReplicationFactor:      3,
+				MultiplyFactor:         100,
+				LoadFactor:             lf,
+				MaxPartitionsPerMember: 400,
+			})
+			require.NoError(t, err)
+			require.NoError(t, h.AddMembers(testMember{id: "big", cap: 100}))
+			for i := 0; i < 10; i++ {
+				require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+			}
+			assert.Equal(t, 400, len(h.PartitionsOwnedBy("big")))
+			for _, ms := range h.Partitions() {
+				require.Len(t, ms, 3)
+				ids := memberIds(ms)
+				for j := range ids {
+					assert.NotContains(t, ids[j+1:], ids[j])
+				}
+			}
+			for _, m := range h.Members() {
+				assert.LessOrEqual(t, len(h.PartitionsOwnedBy(m.Id())), 400)
+			}
 		})
 	}
-	b.ReportAllocs()
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		h.GetMembers(strconv.Itoa(i))
+	t.Run("partitions after the last virtual member", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:         1000,
+			ReplicationFactor:      1,
+			MultiplyFactor:         1,
+			MaxPartitionsPerMember: 110,
+		})
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+		}
+		for _, ms := range h.Partitions() {
+			assert.Len(t, ms, 1)
+		}
+	})
+	t.Run("not enough members", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:         100,
+			ReplicationFactor:      3,
+			MaxPartitionsPerMember: 50,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+		for _, id := range []string{"1", "2", "3"} {
+			assert.Len(t, h.PartitionsOwnedBy(id), 50)
+		}
+		assert.NotEmpty(t, h.UnderReplicatedPartitions())
+	})
+}
+
+func TestCHash_MultiplyFactor(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		assert.Error(t, Config{PartitionCount: 10, ReplicationFactor: 1, MultiplyFactor: -1}.Validate())
+	})
+	t.Run("virtual nodes scale", func(t *testing.T) {
+		for _, mf := range []int{10, 200, 2000} {
+			h, err := New(Config{
+				PartitionCount: 100,
+				MultiplyFactor: mf,
+			})
+			require.NoError(t, err)
+			require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}))
+			assert.Equal(t, mf*3, h.(*cHash[Member]).membersSet.Len())
+		}
+	})
+	t.Run("capacity proportionality", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    3000,
+			ReplicationFactor: 3,
+			MultiplyFactor:    200,
+		})
+		require.NoError(t, err)
+		caps := []float64{1, 2, 3, 4, 6}
+		for i, c := range caps {
+			require.NoError(t, h.AddMembers(&testMember{id: fmt.Sprint("n", i), cap: c}))
+		}
+		var stat = make(map[float64]int)
+		for i := 0; i < h.PartitionCount(); i++ {
+			memb, _ := h.GetPartitionMembers(i)
+			for _, m := range memb {
+				stat[m.Capacity()]++
+			}
+		}
+		var prevCount int
+		for _, c := range caps {
+			assert.Greater(t, stat[c], prevCount)
+			prevCount = stat[c]
+		}
+	})
+}
+
+func TestCHash_MaxVirtualMembers(t *testing.T) {
+	assert.Error(t, Config{PartitionCount: 10, ReplicationFactor: 1, MultiplyFactor: 1, MaxVirtualMembers: -1}.Validate())
+	t.Run("default", func(t *testing.T) {
+		h, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		for _, capacity := range []float64{1e300, math.MaxFloat64} {
+			assert.Equal(t, defaultMaxVirtualMembers, h.(*cHash[Member]).virtualCount(testMember{id: "1", cap: capacity}))
+		}
+	})
+	t.Run("extreme capacity", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+			MaxVirtualMembers: 5000,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1e300}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+		assert.Equal(t, 5000+2*defaultMultiplyFactor, h.(*cHash[Member]).membersSet.Len())
+		require.NoError(t, h.UpdateCapacity("2", math.MaxFloat64))
+		assert.Equal(t, 2*5000+defaultMultiplyFactor, h.(*cHash[Member]).membersSet.Len())
+		for _, ms := range h.Partitions() {
+			assert.Len(t, ms, 2)
+		}
+	})
+}
+
+func TestCHash_GetMembersBytes(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i)
+		assert.Equal(t, h.GetMembers(key), h.GetMembersBytes([]byte(key)))
+	}
+	key := []byte("key")
+	assert.Equal(t, float64(0), testing.AllocsPerRun(100, func() {
+		h.GetMembersBytes(key)
+	}))
+}
+
+func TestCHash_FlatPartitions(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	c := h.(*cHash[Member])
+	assert.Zero(t, c.snapshot.Load().stride)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	s := c.snapshot.Load()
+	assert.Equal(t, 2, s.stride)
+	for i, ms := range h.Partitions() {
+		assert.Equal(t, ms, s.members(i))
+		assert.Equal(t, len(s.members(i)), cap(s.members(i)))
+	}
+
+	// partitions of different length are kept as is
+	partitions := [][]Member{{testMember{id: "1"}}, {testMember{id: "1"}, testMember{id: "2"}}}
+	flat, stride := flatten(partitions)
+	assert.Nil(t, flat)
+	assert.Zero(t, stride)
+	assert.Len(t, partitions[1], 2)
+}
+
+func TestCHash_GetMembersUUID(t *testing.T) {
+	for _, seed := range []uint64{0, 42} {
+		t.Run(fmt.Sprint("seed ", seed), func(t *testing.T) {
+			h, err := New(Config{
+				PartitionCount:    100,
+				ReplicationFactor: 2,
+				Seed:              seed,
+			})
+			require.NoError(t, err)
+			require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+			var differ int
+			for i := 0; i < 100; i++ {
+				var key [16]byte
+				binary.BigEndian.PutUint64(key[8:], uint64(i)*0x9e3779b97f4a7c15)
+				// the byte form is the same key as the bytes passed to GetMembersBytes
+				assert.Equal(t, h.GetMembersBytes(key[:]), h.GetMembersUUID(key))
+				assert.Equal(t, h.GetPartition(string(key[:])), h.GetPartitionUUID(key))
+				// but the canonical string form is another key
+				if h.GetPartitionUUID(key) != h.GetPartition(uuidString(key)) {
+					differ++
+				}
+			}
+			assert.Greater(t, differ, 50)
+			var key [16]byte
+			assert.Equal(t, float64(0), testing.AllocsPerRun(100, func() {
+				h.GetMembersUUID(key)
+				h.GetPartitionUUID(key)
+			}))
+		})
+	}
+	t.Run("custom hasher", func(t *testing.T) {
+		h, err := New(Config{PartitionCount: 100, Hasher: fnvHasher{}})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+		key := [16]byte{1, 2, 3}
+		assert.Equal(t, h.GetPartition(string(key[:])), h.GetPartitionUUID(key))
+	})
+}
+
+// uuidString formats the key like a canonical UUID string
+func uuidString(key [16]byte) string {
+	h := hex.EncodeToString(key[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func TestCHash_GetPartitionMembers(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	t.Run("copy", func(t *testing.T) {
+		ms, err := h.GetPartitionMembers(0)
+		require.NoError(t, err)
+		expected := slices.Clone(ms)
+		ms[0] = testMember{id: "other", cap: 1}
+		ms[0], ms[1] = ms[1], ms[0]
+		ms, err = h.GetPartitionMembers(0)
+		require.NoError(t, err)
+		assert.Equal(t, expected, ms)
+	})
+	t.Run("into", func(t *testing.T) {
+		var buf = make([]Member, 3)
+		n, err := h.GetPartitionMembersInto(1, buf)
+		require.NoError(t, err)
+		ms, _ := h.GetPartitionMembers(1)
+		assert.Equal(t, ms, buf[:n])
+		_, err = h.GetPartitionMembersInto(10, buf)
+		assert.Equal(t, ErrPartitionNotExists, err)
+	})
+}
+
+func TestCHash_RangePartitions(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		t.Run(fmt.Sprint("lazy ", lazy), func(t *testing.T) {
+			h, err := New(Config{
+				PartitionCount:    100,
+				ReplicationFactor: 2,
+				Lazy:              lazy,
+			})
+			require.NoError(t, err)
+			require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+			var visited []int
+			h.RangePartitions(func(partId int, members []Member) bool {
+				visited = append(visited, partId)
+				ms, err := h.GetPartitionMembers(partId)
+				require.NoError(t, err)
+				assert.Equal(t, ms, members)
+				return true
+			})
+			require.Len(t, visited, 100)
+			for i, partId := range visited {
+				assert.Equal(t, i, partId)
+			}
+
+			visited = visited[:0]
+			h.RangePartitions(func(partId int, members []Member) bool {
+				visited = append(visited, partId)
+				return partId < 9
+			})
+			assert.Len(t, visited, 10)
+		})
+	}
+}
+
+func TestCHash_Members(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount: 10,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, h.Members())
+	require.NoError(t, h.AddMembers(testMember{id: "2", cap: 1}, testMember{id: "3", cap: 2}))
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}))
+	assert.Equal(t, []Member{testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 2}}, h.Members())
+}
+
+func TestCHash_MemberCount(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount: 10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, h.MemberCount())
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	assert.Equal(t, 2, h.MemberCount())
+	require.NoError(t, h.RemoveMembers("1"))
+	assert.Equal(t, 1, h.MemberCount())
+	require.NoError(t, h.Reconfigure([]Member{testMember{id: "1", cap: 1}, testMember{id: "3", cap: 1}, testMember{id: "4", cap: 1}}))
+	assert.Equal(t, 3, h.MemberCount())
+}
+
+func TestCHash_GetMember(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount: 10,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 2}))
+	t.Run("exists", func(t *testing.T) {
+		m, ok := h.GetMember("1")
+		require.True(t, ok)
+		assert.Equal(t, testMember{id: "1", cap: 2}, m)
+	})
+	t.Run("not exists", func(t *testing.T) {
+		m, ok := h.GetMember("2")
+		assert.False(t, ok)
+		assert.Nil(t, m)
+	})
+}
+
+func TestCHash_ContainsMember(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount: 10,
+	})
+	require.NoError(t, err)
+	assert.False(t, h.ContainsMember("1"))
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	assert.True(t, h.ContainsMember("1"))
+	require.NoError(t, h.RemoveMembers("1"))
+	assert.False(t, h.ContainsMember("1"))
+	assert.True(t, h.ContainsMember("2"))
+}
+
+func TestCHash_ReplaceMember(t *testing.T) {
+	newRing := func(t *testing.T) CHash {
+		h, err := New(Config{
+			PartitionCount:    1000,
+			ReplicationFactor: 3,
+		})
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+		}
+		return h
+	}
+	t.Run("errors", func(t *testing.T) {
+		h := newRing(t)
+		assert.Equal(t, ErrMemberNotExists, h.ReplaceMember("10", testMember{id: "11", cap: 1}))
+		assert.Equal(t, ErrMemberExists, h.ReplaceMember("1", testMember{id: "2", cap: 1}))
+		assert.Equal(t, ErrInvalidCapacity, h.ReplaceMember("1", testMember{id: "11", cap: 0}))
+		assert.Equal(t, 10, h.MemberCount())
+	})
+	t.Run("minimal movement", func(t *testing.T) {
+		h := newRing(t)
+		before := h.Partitions()
+		var events int
+		h2 := h.Clone()
+		require.NoError(t, h2.RemoveMembers("3"))
+		require.NoError(t, h2.AddMembers(testMember{id: "3b", cap: 1}))
+
+		var changed []int
+		h = newRing(t)
+		hc := h.(*cHash[Member])
+		hc.config.OnRebalance = func(ids []int) {
+			events++
+			changed = ids
+		}
+		require.NoError(t, h.ReplaceMember("3", testMember{id: "3b", cap: 1}))
+		assert.Equal(t, 1, events)
+		assert.False(t, h.ContainsMember("3"))
+		assert.True(t, h.ContainsMember("3b"))
+		assert.Less(t, movedSlots(before, h.Partitions()), movedSlots(before, h2.Partitions()))
+		// only slots of the old member are moved
+		for _, partId := range changed {
+			for j, m := range h.Partitions()[partId] {
+				if m.Id() != before[partId][j].Id() {
+					assert.Equal(t, "3", before[partId][j].Id())
+					assert.Equal(t, "3b", m.Id())
+				}
+			}
+		}
+		t.Run("update capacity", func(t *testing.T) {
+			replaced := h.Partitions()
+			require.NoError(t, h.UpdateCapacity("3b", 0.5))
+			require.NoError(t, h.UpdateCapacity("3b", 1))
+			assert.Equal(t, 0, movedSlots(replaced, h.Partitions()))
+		})
+		t.Run("remove", func(t *testing.T) {
+			require.NoError(t, h.RemoveMembers("3b"))
+			require.NoError(t, h.AddMembers(testMember{id: "3", cap: 1}))
+			assert.Equal(t, 0, movedSlots(before, h.Partitions()))
+		})
+	})
+	t.Run("same id", func(t *testing.T) {
+		h := newRing(t)
+		before := h.Partitions()
+		require.NoError(t, h.ReplaceMember("3", testMember{id: "3", cap: 1, links: []string{"1"}}))
+		assert.Equal(t, 0, movedSlots(before, h.Partitions()))
+		m, _ := h.GetMember("3")
+		assert.Equal(t, []string{"1"}, m.(testMember).links)
+	})
+	t.Run("another capacity", func(t *testing.T) {
+		h := newRing(t)
+		require.NoError(t, h.ReplaceMember("3", testMember{id: "3b", cap: 2}))
+		assert.Greater(t, len(h.PartitionsOwnedBy("3b")), len(h.PartitionsOwnedBy("4")))
+		assert.Len(t, h.MemberPositions("3b"), 2*defaultMultiplyFactor)
+	})
+}
+
+func TestCHash_MoveMember(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    1000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+	}
+	ns, err := h.Namespace(2)
+	require.NoError(t, err)
+	require.NoError(t, h.UpdateCapacity("3", 1.5))
+	owned := h.PartitionsOwnedBy("3")
+	nsPartitions := ns.Partitions()
+
+	assert.Equal(t, ErrMemberNotExists, h.MoveMember("10", testMember{id: "11", cap: 1}))
+	assert.Equal(t, ErrMemberExists, h.MoveMember("3", testMember{id: "4", cap: 1}))
+
+	require.NoError(t, h.MoveMember("3", testMember{id: "3b", cap: 1}))
+	assert.False(t, h.ContainsMember("3"))
+	assert.Empty(t, h.PartitionsOwnedBy("3"))
+	assert.Equal(t, owned, h.PartitionsOwnedBy("3b"))
+	// the capacity set by UpdateCapacity is kept
+	assert.Len(t, h.MemberPositions("3b"), 3000)
+	for i, ms := range ns.Partitions() {
+		for j, m := range ms {
+			if id := nsPartitions[i][j].Id(); id == "3" {
+				assert.Equal(t, "3b", m.Id())
+			} else {
+				assert.Equal(t, id, m.Id())
+			}
+		}
+	}
+
+	// the member keeps its positions, so the next distribution keeps the placement too
+	h.Distribute()
+	assert.Equal(t, owned, h.PartitionsOwnedBy("3b"))
+}
+
+func TestCHash_UpdateCapacity(t *testing.T) {
+	c := Config{ReplicationFactor: 2, PartitionCount: 100}
+	partitionIds := func(h CHash) [][]string {
+		var result = make([][]string, h.PartitionCount())
+		for i := range result {
+			ms, err := h.GetPartitionMembers(i)
+			require.NoError(t, err)
+			for _, m := range ms {
+				result[i] = append(result[i], m.Id())
+			}
+		}
+		return result
+	}
+	t.Run("errors", func(t *testing.T) {
+		h, err := New(c)
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}))
+		assert.Equal(t, ErrMemberNotExists, h.UpdateCapacity("2", 1))
+		assert.Equal(t, ErrInvalidCapacity, h.UpdateCapacity("1", 0))
+		assert.Equal(t, ErrInvalidCapacity, h.UpdateCapacity("1", math.NaN()))
+		assert.Equal(t, ErrInvalidCapacity, h.UpdateCapacity("1", math.Inf(1)))
+		assert.Equal(t, ErrInvalidCapacity, h.UpdateCapacity("1", math.Inf(-1)))
+		assert.Len(t, h.MemberPositions("1"), defaultMultiplyFactor)
+	})
+	for _, capacity := range []float64{0.3, 1, 2.5} {
+		t.Run(fmt.Sprint("capacity ", capacity), func(t *testing.T) {
+			h1, err := New(c)
+			require.NoError(t, err)
+			require.NoError(t, h1.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+			require.NoError(t, h1.UpdateCapacity("2", capacity))
+
+			h2, err := New(c)
+			require.NoError(t, err)
+			require.NoError(t, h2.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: capacity}, testMember{id: "3", cap: 1}))
+
+			assert.Equal(t, h2.(*cHash[Member]).membersSet.Len(), h1.(*cHash[Member]).membersSet.Len())
+			assert.Equal(t, partitionIds(h2), partitionIds(h1))
+		})
+	}
+	for _, lazy := range []bool{false, true} {
+		t.Run(fmt.Sprint("small change lazy ", lazy), func(t *testing.T) {
+			h, err := New(Config{ReplicationFactor: 2, PartitionCount: 1000, Lazy: lazy})
+			require.NoError(t, err)
+			for i := 0; i < 10; i++ {
+				require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+			}
+			before := partitionIds(h)
+			require.NoError(t, h.UpdateCapacity("3", 1.1))
+			after := partitionIds(h)
+
+			var lost, gained int
+			for i := range after {
+				var partitionLost int
+				for _, id := range before[i] {
+					if !slices.Contains(after[i], id) {
+						partitionLost++
+					}
+				}
+				assert.LessOrEqual(t, partitionLost, 1)
+				lost += partitionLost
+				if slices.Contains(after[i], "3") && !slices.Contains(before[i], "3") {
+					gained++
+				}
+			}
+			// the fair share of the member grows by 2000 * (1.1/10.1 - 1/10) ~ 18 slots
+			assert.NotZero(t, gained)
+			assert.LessOrEqual(t, lost, 36)
+			if lazy {
+				// without bounds only new virtual members of the member take partitions
+				assert.Equal(t, gained, lost)
+			}
+		})
+	}
+}
+
+func TestNewG(t *testing.T) {
+	c := Config{PartitionCount: 100, ReplicationFactor: 2}
+	var members = []testMember{{id: "1", cap: 1}, {id: "2", cap: 2}, {id: "3", cap: 1}}
+	hg, err := NewG[testMember](c)
+	require.NoError(t, err)
+	require.NoError(t, hg.AddMembers(members...))
+	h, err := New(c)
+	require.NoError(t, err)
+	for _, m := range members {
+		require.NoError(t, h.AddMembers(m))
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i)
+		var expected []testMember
+		for _, m := range h.GetMembers(key) {
+			expected = append(expected, m.(testMember))
+		}
+		assert.Equal(t, expected, hg.GetMembers(key))
+	}
+}
+
+// cancelAfterCtx is canceled after n checks of Err
+type cancelAfterCtx struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfterCtx) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestCHash_DistributeCtx(t *testing.T) {
+	var members = []Member{testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}}
+	h1, err := New(Config{
+		PartitionCount:    1000,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h1.AddMembers(members...))
+	h2, err := New(Config{
+		PartitionCount:    1000,
+		ReplicationFactor: 2,
+		Hasher:            fnvHasher{},
+	})
+	require.NoError(t, err)
+	require.NoError(t, h2.AddMembers(members...))
+	own := h2.Partitions()
+	data, err := h1.ExportAssignment()
+	require.NoError(t, err)
+	require.NoError(t, h2.ImportAssignment(data))
+	require.NotEqual(t, own, h2.Partitions())
+
+	t.Run("canceled", func(t *testing.T) {
+		assert.Equal(t, context.Canceled, h2.DistributeCtx(&cancelAfterCtx{Context: context.Background(), n: 2}))
+		assert.Equal(t, h1.Partitions(), h2.Partitions())
+		assert.Equal(t, h1.Partitions()[h2.GetPartition("key")], h2.GetMembers("key"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, context.Canceled, h2.DistributeCtx(ctx))
+		assert.Equal(t, h1.Partitions(), h2.Partitions())
+	})
+	t.Run("done", func(t *testing.T) {
+		assert.NoError(t, h2.DistributeCtx(context.Background()))
+		assert.Equal(t, own, h2.Partitions())
+	})
+}
+
+func TestCHash_DistributeParallel(t *testing.T) {
+	// the worker count is forced, so the parallel walk runs even with a single CPU
+	workers := parallelWorkers
+	parallelWorkers = func() int { return 4 }
+	defer func() { parallelWorkers = workers }()
+
+	var members, zoned []Member
+	for i := 0; i < 30; i++ {
+		members = append(members, testMember{id: fmt.Sprint("n", i), cap: float64(i%3 + 1)})
+		zoned = append(zoned, zonedMember{testMember: testMember{id: fmt.Sprint("z", i), cap: 1}, zone: fmt.Sprint(i % 4)})
+	}
+	for _, tc := range []struct {
+		name    string
+		config  Config
+		members []Member
+	}{
+		{"default", Config{}, members},
+		{"tolerance", Config{OverflowTolerance: 0.5}, members},
+		{"load factor", Config{LoadFactor: 1.25}, members},
+		{"max partitions", Config{MaxPartitionsPerMember: 320}, members},
+		{"zones", Config{}, zoned},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.config
+			c.PartitionCount, c.ReplicationFactor = 3000, 3
+			h1, err := New(c)
+			require.NoError(t, err)
+			require.NoError(t, h1.AddMembers(tc.members...))
+			c.Parallel = true
+			h2, err := New(c)
+			require.NoError(t, err)
+			require.NoError(t, h2.AddMembers(tc.members...))
+			assert.Equal(t, h1.Partitions(), h2.Partitions())
+			assert.Equal(t, h1.Stats(), h2.Stats())
+
+			require.NoError(t, h1.DrainMember(tc.members[0].Id()))
+			require.NoError(t, h2.DrainMember(tc.members[0].Id()))
+			assert.Equal(t, h1.Partitions(), h2.Partitions())
+		})
+	}
+}
+
+func TestCHash_Concurrent(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+		MultiplyFactor:    100,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	var done = make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa(j)
+				assert.Len(t, h.GetMembers(key), 2)
+				_, _ = h.GetPartitionMembers(h.GetPartition(key))
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, h.Reconfigure([]Member{testMember{id: "1", cap: 1}, testMember{id: fmt.Sprint("n", i), cap: 1}}))
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+}
+
+func TestCHash_LoadFactor(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		_, err := New(Config{PartitionCount: 10, LoadFactor: 0.5})
+		assert.Error(t, err)
+	})
+	t.Run("bounded", func(t *testing.T) {
+		const (
+			pc = 1000
+			rf = 2
+			lf = 1.1
+		)
+		h, err := New(Config{
+			PartitionCount:    pc,
+			ReplicationFactor: rf,
+			LoadFactor:        lf,
+		})
+		require.NoError(t, err)
+		caps := map[string]float64{"1": 1, "2": 2, "3": 3, "4": 4}
+		for id, c := range caps {
+			require.NoError(t, h.AddMembers(testMember{id: id, cap: c}))
+		}
+		var counts = make(map[string]int)
+		for i := 0; i < pc; i++ {
+			ms, err := h.GetPartitionMembers(i)
+			require.NoError(t, err)
+			require.Len(t, ms, rf)
+			require.NotEqual(t, ms[0].Id(), ms[1].Id())
+			for _, m := range ms {
+				counts[m.Id()]++
+			}
+		}
+		for id, c := range caps {
+			assert.LessOrEqual(t, counts[id], int(math.Ceil(pc*rf*c/10*lf)), id)
+		}
+	})
+	t.Run("not enough members", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 3,
+			LoadFactor:        1.25,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 10}))
+		for i := 0; i < 100; i++ {
+			ms, err := h.GetPartitionMembers(i)
+			require.NoError(t, err)
+			assert.Len(t, ms, 3)
+		}
+	})
+}
+
+func TestCHash_Zones(t *testing.T) {
+	zonesOf := func(ms []Member) map[string]int {
+		var zones = make(map[string]int)
+		for _, m := range ms {
+			if zm, ok := m.(Zoned); ok {
+				zones[zm.Zone()]++
+			} else {
+				zones[m.Id()]++
+			}
+		}
+		return zones
+	}
+	t.Run("replica per zone", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    1000,
+			ReplicationFactor: 3,
+		})
+		require.NoError(t, err)
+		for i := 0; i < 9; i++ {
+			require.NoError(t, h.AddMembers(zonedMember{
+				testMember: testMember{id: fmt.Sprint("n", i), cap: float64(i%2 + 1)},
+				zone:       fmt.Sprint("z", i%3),
+			}))
+		}
+		for i := 0; i < h.PartitionCount(); i++ {
+			ms, err := h.GetPartitionMembers(i)
+			require.NoError(t, err)
+			assert.Len(t, zonesOf(ms), 3, ms)
+		}
+	})
+	t.Run("not enough zones", func(t *testing.T) {
+		for _, lf := range []float64{0, 1.25} {
+			h, err := New(Config{
+				PartitionCount:    100,
+				ReplicationFactor: 3,
+				LoadFactor:        lf,
+			})
+			require.NoError(t, err)
+			for i := 0; i < 4; i++ {
+				require.NoError(t, h.AddMembers(zonedMember{
+					testMember: testMember{id: fmt.Sprint("n", i), cap: 1},
+					zone:       fmt.Sprint("z", i%2),
+				}))
+			}
+			for i := 0; i < h.PartitionCount(); i++ {
+				ms, err := h.GetPartitionMembers(i)
+				require.NoError(t, err)
+				require.Len(t, ms, 3)
+				assert.Len(t, zonesOf(ms), 2, ms)
+			}
+		}
+	})
+}
+
+func TestCHash_SetReplicationFactor(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}, testMember{id: "4", cap: 1}))
+	assert.Error(t, h.SetReplicationFactor(0))
+	require.NoError(t, h.SetReplicationFactor(3))
+	for i := 0; i < h.PartitionCount(); i++ {
+		ms, err := h.GetPartitionMembers(i)
+		require.NoError(t, err)
+		var ids = map[string]bool{}
+		for _, m := range ms {
+			ids[m.Id()] = true
+		}
+		assert.Len(t, ids, 3)
+	}
+}
+
+func TestCHash_SetPartitionCount(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	assert.Error(t, h.SetPartitionCount(5))
+	assert.Equal(t, 10, h.PartitionCount())
+	require.NoError(t, h.SetPartitionCount(1000))
+	assert.Equal(t, 1000, h.PartitionCount())
+	var partitions = map[int]bool{}
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint("k", i)
+		assert.Len(t, h.GetMembers(key), 2)
+		partitions[h.GetPartition(key)] = true
+	}
+	assert.Greater(t, len(partitions), 10)
+	_, err = h.GetPartitionMembers(999)
+	assert.NoError(t, err)
+}
+
+func TestCHash_DrainMember(t *testing.T) {
+	for _, strategy := range []Strategy{nil, RendezvousStrategy{}} {
+		t.Run(fmt.Sprintf("%T", strategy), func(t *testing.T) {
+			h, err := New(Config{
+				PartitionCount:    100,
+				ReplicationFactor: 2,
+				Strategy:          strategy,
+			})
+			require.NoError(t, err)
+			require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+			before := h.Partitions()
+			assert.Equal(t, ErrMemberNotExists, h.DrainMember("4"))
+			require.NoError(t, h.DrainMember("2"))
+			assert.True(t, h.ContainsMember("2"))
+			_, ok := h.GetMember("2")
+			assert.True(t, ok)
+			assert.Empty(t, h.PartitionsOwnedBy("2"))
+			for i := 0; i < h.PartitionCount(); i++ {
+				ms, err := h.GetPartitionMembers(i)
+				require.NoError(t, err)
+				assert.Len(t, ms, 2)
+			}
+			for _, ms := range h.GetMembersMany([]string{"a", "b", "c"}) {
+				for _, m := range ms {
+					assert.NotEqual(t, "2", m.Id())
+				}
+			}
+			assert.Len(t, h.GetMembersN("a", 3), 2)
+
+			require.NoError(t, h.DrainMember("1"))
+			require.NoError(t, h.DrainMember("3"))
+			assert.Empty(t, h.GetMembers("a"))
+
+			require.NoError(t, h.UndrainMember("1"))
+			require.NoError(t, h.UndrainMember("2"))
+			require.NoError(t, h.UndrainMember("3"))
+			assert.Equal(t, before, h.Partitions())
+		})
+	}
+}
+
+func TestCHash_Standby(t *testing.T) {
+	newRing := func(t *testing.T) CHash {
+		h, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, standbyMember{testMember{id: "s", cap: 1}}))
+		return h
+	}
+	t.Run("idle", func(t *testing.T) {
+		h := newRing(t)
+		assert.Empty(t, h.PartitionsOwnedBy("s"))
+		assert.Len(t, h.PartitionsOwnedBy("1"), 100)
+		assert.Len(t, h.PartitionsOwnedBy("2"), 100)
+		assert.Equal(t, []string{"s"}, h.OrphanMembers())
+		assert.Contains(t, h.Dump(), "member s: capacity 1, partitions 0, standby\n")
+		assert.NotContains(t, h.Dump(), "drained")
+	})
+	t.Run("replaces removed member", func(t *testing.T) {
+		h := newRing(t)
+		require.NoError(t, h.RemoveMembers("1"))
+		assert.Len(t, h.PartitionsOwnedBy("s"), 100)
+		assert.Empty(t, h.UnderReplicatedPartitions())
+
+		require.NoError(t, h.AddMembers(testMember{id: "3", cap: 1}))
+		assert.Empty(t, h.PartitionsOwnedBy("s"))
+	})
+	t.Run("replaces drained member", func(t *testing.T) {
+		h := newRing(t)
+		require.NoError(t, h.DrainMember("2"))
+		assert.Len(t, h.PartitionsOwnedBy("s"), 100)
+		require.NoError(t, h.UndrainMember("2"))
+		assert.Empty(t, h.PartitionsOwnedBy("s"))
+	})
+	t.Run("promote", func(t *testing.T) {
+		h := newRing(t)
+		assert.Equal(t, ErrMemberNotExists, h.Promote("4"))
+		require.NoError(t, h.Promote("1"))
+		require.NoError(t, h.Promote("s"))
+		assert.NotEmpty(t, h.PartitionsOwnedBy("s"))
+		assert.Less(t, len(h.PartitionsOwnedBy("1")), 100)
+	})
+	t.Run("full replication", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:         100,
+			ReplicationFactor:      2,
+			RequireFullReplication: true,
+		})
+		require.NoError(t, err)
+		assert.ErrorIs(t, h.AddMembers(standbyMember{testMember{id: "s", cap: 1}}), ErrInsufficientMembers)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, standbyMember{testMember{id: "s", cap: 1}}))
+		assert.Len(t, h.PartitionsOwnedBy("s"), 100)
+		assert.ErrorIs(t, h.RemoveMembers("s"), ErrInsufficientMembers)
+	})
+}
+
+func TestCHash_PartitionCount(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 10, h.PartitionCount())
+}
+
+func TestCHash_ReplicationFactor(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, h.ReplicationFactor())
+	assert.Equal(t, 0, h.EffectiveReplicationFactor())
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	assert.Equal(t, 3, h.ReplicationFactor())
+	assert.Equal(t, 2, h.EffectiveReplicationFactor())
+	require.NoError(t, h.DrainMember("2"))
+	assert.Equal(t, 1, h.EffectiveReplicationFactor())
+	require.NoError(t, h.SetReplicationFactor(1))
+	require.NoError(t, h.AddMembers(testMember{id: "3", cap: 1}))
+	assert.Equal(t, 1, h.ReplicationFactor())
+	assert.Equal(t, 1, h.EffectiveReplicationFactor())
+}
+
+func BenchmarkCHash_GetMembers(b *testing.B) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(b, err)
+	for i := 0; i < 30; i++ {
+		h.AddMembers(&testMember{
+			id:  fmt.Sprint("n", i),
+			cap: 1,
+		})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.GetMembers(strconv.Itoa(i))
+	}
+}
+
+func BenchmarkCHash_UnsafeStringHash(b *testing.B) {
+	for _, unsafeStrings := range []bool{false, true} {
+		b.Run(fmt.Sprint("unsafe ", unsafeStrings), func(b *testing.B) {
+			h, err := New(Config{
+				PartitionCount:    3000,
+				ReplicationFactor: 3,
+				Hasher:            bytesHasher{},
+				UnsafeStringHash:  unsafeStrings,
+			})
+			require.NoError(b, err)
+			for i := 0; i < 30; i++ {
+				require.NoError(b, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+			}
+			var keys = make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprint("key-", i)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.GetMembers(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+func BenchmarkCHash_PartitionLookup(b *testing.B) {
+	for _, pc := range []uint64{4000, 4096} {
+		b.Run(fmt.Sprint(pc), func(b *testing.B) {
+			h, err := New(Config{
+				PartitionCount: pc,
+			})
+			require.NoError(b, err)
+			var key = []byte("key")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.GetMembersBytes(key)
+			}
+		})
+	}
+}
+
+func BenchmarkCHash_GetMembersBytes(b *testing.B) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(b, err)
+	for i := 0; i < 30; i++ {
+		h.AddMembers(&testMember{
+			id:  fmt.Sprint("n", i),
+			cap: 1,
+		})
+	}
+	var keys = make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.GetMembersBytes(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkCHash_GetMembersUUID(b *testing.B) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(b, err)
+	for i := 0; i < 30; i++ {
+		h.AddMembers(&testMember{
+			id:  fmt.Sprint("n", i),
+			cap: 1,
+		})
+	}
+	var keys = make([][16]byte, 1000)
+	for i := range keys {
+		binary.BigEndian.PutUint64(keys[i][8:], uint64(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.GetMembersUUID(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkCHash_FlatLookup(b *testing.B) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(b, err)
+	for i := 0; i < 30; i++ {
+		h.AddMembers(&testMember{
+			id:  fmt.Sprint("n", i),
+			cap: 1,
+		})
+	}
+	var keys = make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+	c := h.(*cHash[Member])
+	flat := c.snapshot.Load()
+	table := *flat
+	table.flat, table.stride = nil, 0
+	for _, s := range []*snapshot[Member]{flat, &table} {
+		name := "flat"
+		if s.stride == 0 {
+			name = "table"
+		}
+		b.Run(name, func(b *testing.B) {
+			c.snapshot.Store(s)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.GetMembersBytes(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+func BenchmarkCHash_DistributeParallel(b *testing.B) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+		Parallel:          true,
+	})
+	require.NoError(b, err)
+	for i := 0; i < 100; i++ {
+		h.AddMembers(&testMember{
+			id:  fmt.Sprint("n", i),
+			cap: 1,
+		})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Distribute()
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = New(Config{
+			PartitionCount:    3000,
+			ReplicationFactor: 3,
+		})
+	}
+}
+
+func BenchmarkCHash_AddMembers(b *testing.B) {
+	var members = make([]Member, 100)
+	for i := range members {
+		members[i] = testMember{id: fmt.Sprint("n", i), cap: 1}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h, _ := New(Config{
+			PartitionCount:    3000,
+			ReplicationFactor: 3,
+		})
+		_ = h.AddMembers(members...)
+	}
+}
+
+func BenchmarkCHash_BulkLoad(b *testing.B) {
+	var members = make([]Member, 200)
+	for i := range members {
+		members[i] = testMember{id: fmt.Sprint("n", i), cap: 1}
+	}
+	var newRing = func() CHash {
+		h, _ := New(Config{
+			PartitionCount:    3000,
+			ReplicationFactor: 3,
+			MultiplyFactor:    100,
+		})
+		return h
+	}
+	b.Run("add", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h := newRing()
+			for _, m := range members {
+				_ = h.AddMembers(m)
+			}
+		}
+	})
+	b.Run("deferred", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h := newRing()
+			for _, m := range members {
+				_ = h.AddMembersDeferred(m)
+			}
+			_ = h.Commit()
+		}
+	})
+}
+
+func BenchmarkCHash_Reconfigure(b *testing.B) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(b, err)
+	var members = make([]testMember, 100)
+	for i := range members {
+		members[i] = testMember{id: fmt.Sprint("n", i), cap: 1}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var ms = make([]Member, len(members))
+		for j, m := range members {
+			ms[j] = m
+		}
+		_ = h.Reconfigure(ms)
+	}
+}
+
+func BenchmarkCHashG_Reconfigure(b *testing.B) {
+	h, err := NewG[testMember](Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(b, err)
+	var members = make([]testMember, 100)
+	for i := range members {
+		members[i] = testMember{id: fmt.Sprint("n", i), cap: 1}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = h.Reconfigure(members)
 	}
 }
 
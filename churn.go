@@ -0,0 +1,64 @@
+package chash
+
+import "golang.org/x/exp/slices"
+
+func (c *cHash[M]) ReplicaChangeViolations() []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.violations)
+}
+
+// limitReplicaChanges keeps prior owners in new partitions, so every partition loses at most Config.MaxReplicaChangePerPartition of them
+// kept owners replace members new to the partition starting from the last replica, so the preferred members of the new table stay first
+// it returns ids of partitions losing more prior owners, because the owners can't own partitions anymore or there are not enough new slots
+func (c *cHash[M]) limitReplicaChanges(old, new [][]M) (violations []int) {
+	limit := c.config.MaxReplicaChangePerPartition
+	var lost []string
+	for i, ms := range new {
+		if i >= len(old) {
+			break
+		}
+		lost = lost[:0]
+		for _, m := range old[i] {
+			if !containsId(ms, m.Id()) {
+				lost = append(lost, m.Id())
+			}
+		}
+		if len(lost) <= limit {
+			continue
+		}
+		keep := len(lost) - limit
+		slot := len(ms) - 1
+		for _, id := range lost {
+			if keep == 0 {
+				break
+			}
+			if _, ok := c.members[id]; !ok || !c.isPlaceable(id) {
+				continue
+			}
+			for slot >= 0 && containsId(old[i], ms[slot].Id()) {
+				slot--
+			}
+			if slot < 0 {
+				break
+			}
+			ms[slot] = c.members[id]
+			slot--
+			keep--
+		}
+		if keep != 0 {
+			violations = append(violations, i)
+		}
+	}
+	return
+}
+
+// containsId checks whether members contain the member with the id
+func containsId[M Member](ms []M, id string) bool {
+	for _, m := range ms {
+		if m.Id() == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package chash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_MaxReplicaChangePerPartition(t *testing.T) {
+	assert.Error(t, Config{PartitionCount: 10, ReplicationFactor: 1, MultiplyFactor: 1, MaxReplicaChangePerPartition: -1}.Validate())
+	assert.Error(t, Config{PartitionCount: 10, ReplicationFactor: 1, MultiplyFactor: 1, MaxReplicaChangePerPartition: 1, Lazy: true}.Validate())
+
+	var members []Member
+	for i := 0; i < 10; i++ {
+		members = append(members, testMember{id: fmt.Sprint(i), cap: 1})
+	}
+	newRing := func(t *testing.T, limit int) CHash {
+		h, err := New(Config{
+			PartitionCount:               500,
+			ReplicationFactor:            3,
+			MultiplyFactor:               100,
+			MaxReplicaChangePerPartition: limit,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(members[:3]...))
+		return h
+	}
+	// maxLost returns the most prior owners lost by a partition
+	maxLost := func(before, after [][]Member) (max int) {
+		for i := range after {
+			var lost int
+			for _, m := range before[i] {
+				if !containsId(after[i], m.Id()) {
+					lost++
+				}
+			}
+			if lost > max {
+				max = lost
+			}
+		}
+		return
+	}
+
+	unlimited := newRing(t, 0)
+	before := unlimited.Partitions()
+	require.NoError(t, unlimited.AddMembers(members[3:]...))
+	require.Equal(t, 3, maxLost(before, unlimited.Partitions()))
+
+	t.Run("add members", func(t *testing.T) {
+		h := newRing(t, 1)
+		require.NoError(t, h.AddMembers(members[3:]...))
+		after := h.Partitions()
+		assert.Equal(t, 1, maxLost(before, after))
+		assert.Empty(t, h.ReplicaChangeViolations())
+		for _, ms := range after {
+			require.Len(t, ms, 3)
+			ids := memberIds(ms)
+			for j := range ids {
+				assert.NotContains(t, ids[j+1:], ids[j])
+			}
+		}
+		assert.False(t, h.Equal(unlimited))
+
+		// every distribution moves one more replica until the table is the unlimited one
+		for i := 0; i < 2; i++ {
+			before := h.Partitions()
+			h.Distribute()
+			assert.LessOrEqual(t, maxLost(before, h.Partitions()), 1)
+		}
+		assert.True(t, h.Equal(unlimited))
+	})
+	t.Run("removed owners", func(t *testing.T) {
+		h := newRing(t, 1)
+		require.NoError(t, h.AddMembers(members[3:]...))
+		for i := 0; i < 2; i++ {
+			h.Distribute()
+		}
+		before := h.Partitions()
+		require.NoError(t, h.RemoveMembers("0", "1"))
+		violations := h.ReplicaChangeViolations()
+		assert.NotEmpty(t, violations)
+		for _, partId := range violations {
+			ids := memberIds(before[partId])
+			assert.Contains(t, ids, "0")
+			assert.Contains(t, ids, "1")
+		}
+		assert.Equal(t, violations, h.Clone().ReplicaChangeViolations())
+		assert.Equal(t, 2, maxLost(before, h.Partitions()))
+
+		h.Distribute()
+		assert.Empty(t, h.ReplicaChangeViolations())
+	})
+}
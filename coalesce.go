@@ -0,0 +1,47 @@
+package chash
+
+import "time"
+
+// coalesceLimit is how many windows the distribution may be postponed by changes coming one after another
+const coalesceLimit = 10
+
+// coalescing is the state of the distribution postponed by Config.CoalesceWindow
+type coalescing struct {
+	pending bool
+	// since is the time of the first postponed change
+	since time.Time
+	timer *time.Timer
+}
+
+// scheduleDistribute postpones the distribution until no changes come for the window
+func (c *cHash[M]) scheduleDistribute() {
+	var window = c.config.CoalesceWindow
+	if !c.coalesce.pending {
+		c.coalesce.pending, c.coalesce.since = true, time.Now()
+	} else if time.Since(c.coalesce.since) >= coalesceLimit*window {
+		// the timer isn't postponed anymore, so steady changes don't starve the distribution
+		return
+	}
+	if c.coalesce.timer == nil {
+		c.coalesce.timer = time.AfterFunc(window, c.Flush)
+	} else {
+		c.coalesce.timer.Reset(window)
+	}
+}
+
+// stopCoalescing drops the postponed distribution, it's called when partitions are distributed
+func (c *cHash[M]) stopCoalescing() {
+	if !c.coalesce.pending {
+		return
+	}
+	c.coalesce.pending = false
+	c.coalesce.timer.Stop()
+}
+
+func (c *cHash[M]) Flush() {
+	c.lock()
+	defer c.unlock()
+	if c.coalesce.pending {
+		c.distributeNow()
+	}
+}
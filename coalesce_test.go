@@ -0,0 +1,105 @@
+package chash
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_CoalesceWindow(t *testing.T) {
+	newRing := func(t *testing.T, window time.Duration) CHash {
+		h, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+			CoalesceWindow:    window,
+		})
+		require.NoError(t, err)
+		return h
+	}
+	t.Run("rapid changes", func(t *testing.T) {
+		h := newRing(t, time.Hour)
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, 100, h.MemberCount())
+		assert.Len(t, h.Members(), 100)
+		assert.Zero(t, h.Version())
+		assert.Empty(t, h.GetMembers("key"))
+
+		h.Flush()
+		assert.Equal(t, uint64(1), h.Version())
+		assert.Len(t, h.GetMembers("key"), 2)
+		h.Flush()
+		assert.Equal(t, uint64(1), h.Version())
+	})
+	t.Run("window", func(t *testing.T) {
+		h := newRing(t, 20*time.Millisecond)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+		}
+		assert.Eventually(t, func() bool {
+			return h.Version() != 0
+		}, time.Second, time.Millisecond)
+		assert.Less(t, h.Version(), uint64(50))
+		assert.Len(t, h.GetMembers("key"), 2)
+		ms, err := h.GetPartitionMembers(0)
+		require.NoError(t, err)
+		assert.Len(t, ms, 2)
+	})
+	t.Run("explicit distribution", func(t *testing.T) {
+		h := newRing(t, time.Hour)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+		clone := h.Clone()
+		assert.Len(t, clone.GetMembers("key"), 2)
+		require.NoError(t, clone.AddMembers(testMember{id: "3", cap: 1}))
+		assert.NotEmpty(t, clone.PartitionsOwnedBy("3"))
+
+		h.Distribute()
+		assert.Len(t, h.GetMembers("key"), 2)
+		version := h.Version()
+		h.Flush()
+		assert.Equal(t, version, h.Version())
+	})
+	t.Run("staged", func(t *testing.T) {
+		h := newRing(t, time.Hour)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+		h.Flush()
+		require.NoError(t, h.StageReconfigure([]Member{testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}}))
+		require.NoError(t, h.AddMembers(testMember{id: "4", cap: 1}))
+		assert.Nil(t, h.PendingDiff())
+		assert.Equal(t, ErrNotStaged, h.CommitStaged())
+
+		// the staged copy distributes postponed changes, so committing it drops the postponed distribution
+		require.NoError(t, h.StageReconfigure([]Member{testMember{id: "3", cap: 1}, testMember{id: "4", cap: 1}}))
+		require.NoError(t, h.CommitStaged())
+		assert.False(t, h.DistributeIfChanged())
+		version := h.Version()
+		h.Flush()
+		assert.Equal(t, version, h.Version())
+		assert.ElementsMatch(t, []string{"3", "4"}, memberIds(h.GetMembers("key")))
+	})
+	t.Run("partition count", func(t *testing.T) {
+		h := newRing(t, time.Hour)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+		h.Flush()
+		require.NoError(t, h.AddMembers(testMember{id: "3", cap: 1}))
+		require.NoError(t, h.SetPartitionCount(10))
+		assert.Equal(t, 10, h.PartitionCount())
+		assert.NotEmpty(t, h.PartitionsOwnedBy("3"))
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint("k", i)
+			assert.Len(t, h.GetMembersN(key, 3), 3)
+			assert.Len(t, h.GetMembersExcluding(key, "1"), 2)
+		}
+	})
+}
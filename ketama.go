@@ -0,0 +1,82 @@
+package chash
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Ketama is the continuum of libketama used by memcached clients
+// It maps keys to members directly without partitions, so it's not a placement of the ring, but it lets a service
+// find members the same way as ketama clients do while they are migrated to the ring
+// Member ids are the server strings of clients, e.g. "10.0.0.1:11211", and the weight (see Weighted) or the capacity is the server memory
+type Ketama[M Member] struct {
+	points  []uint32
+	members []M
+}
+
+type ketamaPoint struct {
+	point uint32
+	idx   int
+}
+
+// NewKetama builds the continuum of members
+// May return ErrInvalidCapacity, ErrInvalidWeight or ErrMemberExists if ids are not unique
+func NewKetama[M Member](members []M) (*Ketama[M], error) {
+	if err := validateMembers(members, map[string]M(nil)); err != nil {
+		return nil, err
+	}
+	var total float64
+	for _, m := range members {
+		total += ketamaWeight(m)
+	}
+	var points []ketamaPoint
+	var buf []byte
+	for i, m := range members {
+		// libketama computes the share as float
+		share := float32(ketamaWeight(m)) / float32(total)
+		count := int(math.Floor(float64(share) * 40 * float64(len(members))))
+		for k := 0; k < count; k++ {
+			buf = strconv.AppendInt(append(append(buf[:0], m.Id()...), '-'), int64(k), 10)
+			digest := md5.Sum(buf)
+			for h := 0; h < 4; h++ {
+				points = append(points, ketamaPoint{point: binary.LittleEndian.Uint32(digest[h*4:]), idx: i})
+			}
+		}
+	}
+	// libketama sorts points by qsort, so the owner of equal points isn't defined there, here the member given first owns them
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].point < points[j].point
+	})
+	var k = &Ketama[M]{points: make([]uint32, len(points)), members: make([]M, len(points))}
+	for i, p := range points {
+		k.points[i], k.members[i] = p.point, members[p.idx]
+	}
+	return k, nil
+}
+
+// GetPrimary returns the member owning the key, false when there are no members
+func (k *Ketama[M]) GetPrimary(key string) (m M, ok bool) {
+	if len(k.points) == 0 {
+		return
+	}
+	digest := md5.Sum([]byte(key))
+	h := binary.LittleEndian.Uint32(digest[:4])
+	i := sort.Search(len(k.points), func(i int) bool {
+		return k.points[i] >= h
+	})
+	if i == len(k.points) {
+		i = 0
+	}
+	return k.members[i], true
+}
+
+// ketamaWeight returns the member's weight or capacity
+func ketamaWeight(m Member) float64 {
+	if wm, ok := m.(Weighted); ok {
+		return wm.Weight()
+	}
+	return m.Capacity()
+}
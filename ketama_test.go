@@ -0,0 +1,61 @@
+package chash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKetama(t *testing.T) {
+	t.Run("reference", func(t *testing.T) {
+		// expectations are computed by a libketama compatible continuum
+		equal, err := NewKetama([]Member{
+			testMember{id: "10.0.0.1:11211", cap: 1},
+			testMember{id: "10.0.0.2:11211", cap: 1},
+			testMember{id: "10.0.0.3:11211", cap: 1},
+		})
+		require.NoError(t, err)
+		weighted, err := NewKetama([]Member{
+			testMember{id: "10.0.0.1:11211", cap: 100},
+			testMember{id: "10.0.0.2:11211", cap: 200},
+			testMember{id: "10.0.0.3:11211", cap: 300},
+		})
+		require.NoError(t, err)
+		assert.Len(t, equal.points, 480)
+		assert.Len(t, weighted.points, 480)
+		for _, v := range []struct {
+			key, equal, weighted string
+		}{
+			{"foo", "10.0.0.3:11211", "10.0.0.3:11211"},
+			{"bar", "10.0.0.1:11211", "10.0.0.1:11211"},
+			{"baz", "10.0.0.3:11211", "10.0.0.3:11211"},
+			{"key1", "10.0.0.1:11211", "10.0.0.3:11211"},
+			{"key2", "10.0.0.3:11211", "10.0.0.3:11211"},
+			{"user:42", "10.0.0.1:11211", "10.0.0.1:11211"},
+			{"session:abc", "10.0.0.3:11211", "10.0.0.3:11211"},
+			{"", "10.0.0.2:11211", "10.0.0.2:11211"},
+			{"hello world", "10.0.0.1:11211", "10.0.0.1:11211"},
+			{"memcached", "10.0.0.3:11211", "10.0.0.3:11211"},
+		} {
+			m, ok := equal.GetPrimary(v.key)
+			require.True(t, ok)
+			assert.Equal(t, v.equal, m.Id(), v.key)
+			m, ok = weighted.GetPrimary(v.key)
+			require.True(t, ok)
+			assert.Equal(t, v.weighted, m.Id(), v.key)
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		k, err := NewKetama[Member](nil)
+		require.NoError(t, err)
+		_, ok := k.GetPrimary("key")
+		assert.False(t, ok)
+	})
+	t.Run("errors", func(t *testing.T) {
+		_, err := NewKetama([]Member{testMember{id: "1", cap: 1}, testMember{id: "1", cap: 1}})
+		assert.Equal(t, ErrMemberExists, err)
+		_, err = NewKetama([]Member{testMember{id: "1"}})
+		assert.Equal(t, ErrInvalidCapacity, err)
+	})
+}
@@ -0,0 +1,131 @@
+package chash
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// keyCacheMaxShards limits count of independently locked parts of the key cache
+	keyCacheMaxShards = 16
+	// keyCacheMinShardSize is the least count of entries a shard is created for, small caches aren't sharded
+	keyCacheMinShardSize = 64
+)
+
+// keyCache is a cache of key partitions with CLOCK eviction
+// partitions of keys don't depend on members, so entries are valid until the partition count is changed
+// every entry keeps the generation of partition hashes it was computed for, entries of other generations are misses
+// hits only take a shared lock of one shard and mark the entry as referenced, so concurrent lookups don't serialize
+type keyCache struct {
+	seed   maphash.Seed
+	mask   uint64
+	shards []keyCacheShard
+}
+
+type keyCacheShard struct {
+	mu      sync.RWMutex
+	size    int
+	index   map[string]int
+	entries []keyCacheEntry
+	// hand is the position the next eviction starts the scan from
+	hand int
+}
+
+type keyCacheEntry struct {
+	key       string
+	gen       uint64
+	partition int
+	// referenced is set by hits and cleared by the clock hand, an entry is evicted when the hand finds it unset
+	referenced atomic.Bool
+}
+
+func newKeyCache(size int) *keyCache {
+	shards := 1
+	for shards < keyCacheMaxShards && size/(shards*2) >= keyCacheMinShardSize {
+		shards *= 2
+	}
+	kc := &keyCache{
+		seed:   maphash.MakeSeed(),
+		mask:   uint64(shards - 1),
+		shards: make([]keyCacheShard, shards),
+	}
+	for i := range kc.shards {
+		shardSize := size / shards
+		if i < size%shards {
+			shardSize++
+		}
+		kc.shards[i] = keyCacheShard{
+			size:    shardSize,
+			index:   make(map[string]int, shardSize),
+			entries: make([]keyCacheEntry, 0, shardSize),
+		}
+	}
+	return kc
+}
+
+func (kc *keyCache) shard(key string) *keyCacheShard {
+	if kc.mask == 0 {
+		return &kc.shards[0]
+	}
+	return &kc.shards[maphash.String(kc.seed, key)&kc.mask]
+}
+
+// get returns the cached partition of the key computed for the generation
+func (kc *keyCache) get(key string, gen uint64) (partition int, ok bool) {
+	s := kc.shard(key)
+	s.mu.RLock()
+	if i, found := s.index[key]; found && s.entries[i].gen == gen {
+		entry := &s.entries[i]
+		// hot entries are already marked, loading first keeps their cache line shared between readers
+		if !entry.referenced.Load() {
+			entry.referenced.Store(true)
+		}
+		partition, ok = entry.partition, true
+	}
+	s.mu.RUnlock()
+	return
+}
+
+// put caches the partition of the key, the first unreferenced entry after the clock hand is evicted when the shard is full
+func (kc *keyCache) put(key string, gen uint64, partition int) {
+	s := kc.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size == 0 {
+		return
+	}
+	if i, ok := s.index[key]; ok {
+		entry := &s.entries[i]
+		entry.gen, entry.partition = gen, partition
+		entry.referenced.Store(true)
+		return
+	}
+	if len(s.entries) < s.size {
+		s.entries = append(s.entries, keyCacheEntry{key: key, gen: gen, partition: partition})
+		s.index[key] = len(s.entries) - 1
+		return
+	}
+	// every entry is passed at most once before an unreferenced one is found, because the hand clears marks
+	for s.entries[s.hand].referenced.Load() {
+		s.entries[s.hand].referenced.Store(false)
+		s.hand = (s.hand + 1) % len(s.entries)
+	}
+	entry := &s.entries[s.hand]
+	delete(s.index, entry.key)
+	entry.key, entry.gen, entry.partition = key, gen, partition
+	s.index[key] = s.hand
+	s.hand = (s.hand + 1) % len(s.entries)
+}
+
+// len returns count of cached entries
+func (kc *keyCache) len() int {
+	var n int
+	for i := range kc.shards {
+		s := &kc.shards[i]
+		s.mu.RLock()
+		n += len(s.entries)
+		s.mu.RUnlock()
+	}
+	return n
+}
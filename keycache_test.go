@@ -0,0 +1,110 @@
+package chash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_KeyCache(t *testing.T) {
+	h, err := NewWithOptions(WithPartitionCount(100), WithReplicationFactor(2), WithKeyCacheSize(10))
+	require.NoError(t, err)
+	uncached, err := NewWithOptions(WithPartitionCount(100), WithReplicationFactor(2))
+	require.NoError(t, err)
+	for _, r := range []CHash{h, uncached} {
+		require.NoError(t, r.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	}
+	cache := h.(*cHash[Member]).keyCache
+
+	t.Run("hits", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, uncached.GetPartition("hot"), h.GetPartition("hot"))
+			assert.Equal(t, uncached.GetMembers("hot"), h.GetMembers("hot"))
+		}
+		partition, ok := cache.get("hot", h.(*cHash[Member]).keyGen)
+		require.True(t, ok)
+		assert.Equal(t, uncached.GetPartition("hot"), partition)
+	})
+	t.Run("lru", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprint("key", i)
+			assert.Equal(t, uncached.GetPartition(key), h.GetPartition(key))
+		}
+		assert.Equal(t, 10, cache.len())
+		_, ok := cache.get("key19", h.(*cHash[Member]).keyGen)
+		assert.True(t, ok)
+		_, ok = cache.get("key0", h.(*cHash[Member]).keyGen)
+		assert.False(t, ok)
+	})
+	t.Run("members change", func(t *testing.T) {
+		require.NoError(t, h.AddMembers(testMember{id: "4", cap: 1}))
+		require.NoError(t, uncached.AddMembers(testMember{id: "4", cap: 1}))
+		_, ok := cache.get("key19", h.(*cHash[Member]).keyGen)
+		assert.True(t, ok)
+		assert.Equal(t, uncached.GetMembers("key19"), h.GetMembers("key19"))
+	})
+	t.Run("partition count change", func(t *testing.T) {
+		gen := h.(*cHash[Member]).keyGen
+		require.NoError(t, h.SetPartitionCount(50))
+		require.NoError(t, uncached.SetPartitionCount(50))
+		_, ok := cache.get("key19", h.(*cHash[Member]).keyGen)
+		assert.False(t, ok)
+		assert.NotEqual(t, gen, h.(*cHash[Member]).keyGen)
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprint("key", i)
+			assert.Equal(t, uncached.GetPartition(key), h.GetPartition(key))
+			assert.Equal(t, uncached.GetMembers(key), h.GetMembers(key))
+		}
+	})
+}
+
+// sha256Hasher stands for an expensive key hasher, the key cache is meant to save its cost
+type sha256Hasher struct{}
+
+func (sha256Hasher) Sum64(data []byte) uint64 {
+	sum := sha256.Sum256(data)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func BenchmarkCHash_KeyCacheParallel(b *testing.B) {
+	var keys = make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, hasher := range []struct {
+		name   string
+		hasher Hasher
+	}{{"xxhash", nil}, {"sha256", sha256Hasher{}}} {
+		for _, size := range []int{0, 4096} {
+			b.Run(fmt.Sprintf("%s/cache=%d", hasher.name, size), func(b *testing.B) {
+				h, err := New(Config{
+					PartitionCount:    3000,
+					ReplicationFactor: 3,
+					KeyHasher:         hasher.hasher,
+					KeyCacheSize:      size,
+				})
+				require.NoError(b, err)
+				for i := 0; i < 30; i++ {
+					require.NoError(b, h.AddMembers(&testMember{id: fmt.Sprint("n", i), cap: 1}))
+				}
+				for _, key := range keys {
+					h.GetMembers(key)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					var i int
+					for pb.Next() {
+						h.GetMembers(keys[i%len(keys)])
+						i++
+					}
+				})
+			})
+		}
+	}
+}
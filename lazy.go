@@ -0,0 +1,99 @@
+package chash
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/exp/slices"
+)
+
+// lazyPartitions computes members of partitions on first access
+// Members of a partition depend only on the frozen ring, so partitions are computed independently and in any order
+type lazyPartitions[M Member] struct {
+	ring            members[M]
+	partitionHashes []uint64
+	rf              int
+	// idle are ids of members that can't own partitions
+	idle      map[string]struct{}
+	zones     map[string]string
+	zoneCount int
+	cells     []atomic.Pointer[[]M]
+	all       atomic.Pointer[[][]M]
+}
+
+// lazyPartitions freezes the ring state needed to compute partitions with the replication factor
+func (c *cHash[M]) lazyPartitions(rf int) *lazyPartitions[M] {
+	lp := &lazyPartitions[M]{
+		ring:            c.membersSet.clone(),
+		partitionHashes: c.partitionHashes,
+		rf:              c.limitReplicationFactor(rf),
+		idle:            make(map[string]struct{}),
+		zones:           c.zones,
+		zoneCount:       c.zoneCount,
+		cells:           make([]atomic.Pointer[[]M], len(c.partitionHashes)),
+	}
+	for id := range c.members {
+		if !c.isPlaceable(id) {
+			lp.idle[id] = struct{}{}
+		}
+	}
+	return lp
+}
+
+// members returns members of the partition computing them on first access
+func (lp *lazyPartitions[M]) members(partId int) []M {
+	if ms := lp.cells[partId].Load(); ms != nil {
+		return *ms
+	}
+	ms := lp.compute(partId)
+	// concurrent readers compute the same members, the first stored ones are returned to all of them
+	if !lp.cells[partId].CompareAndSwap(nil, &ms) {
+		return *lp.cells[partId].Load()
+	}
+	return ms
+}
+
+// table returns members of all partitions
+func (lp *lazyPartitions[M]) table() [][]M {
+	if all := lp.all.Load(); all != nil {
+		return *all
+	}
+	var all = make([][]M, len(lp.cells))
+	for i := range all {
+		all[i] = lp.members(i)
+	}
+	lp.all.CompareAndSwap(nil, &all)
+	return *lp.all.Load()
+}
+
+// compute walks the ring clockwise from the partition position taking the first rf distinct members
+// members of used zones are skipped while there are unused zones, like fillClosest does
+func (lp *lazyPartitions[M]) compute(partId int) []M {
+	var ms = make([]M, 0, lp.rf)
+	if lp.rf == 0 {
+		return ms
+	}
+	var (
+		ids       = make([]string, 0, lp.rf)
+		usedZones []string
+		idx       = lp.ring.search(lp.partitionHashes[partId])
+	)
+	for step := 0; len(ms) < lp.rf && step < 2*lp.ring.Len(); step, idx = step+1, idx+1 {
+		if idx == lp.ring.Len() {
+			idx = 0
+		}
+		id := lp.ring.id(idx)
+		if _, idle := lp.idle[id]; idle || slices.Contains(ids, id) {
+			continue
+		}
+		// every zone is used after the first lap, so the second lap takes the rest when zones are less than rf
+		if len(usedZones) < lp.zoneCount && slices.Contains(usedZones, lp.zones[id]) {
+			continue
+		}
+		ms = append(ms, lp.ring.member(idx))
+		ids = append(ids, id)
+		if lp.zones != nil && !slices.Contains(usedZones, lp.zones[id]) {
+			usedZones = append(usedZones, lp.zones[id])
+		}
+	}
+	return ms
+}
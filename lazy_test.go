@@ -0,0 +1,152 @@
+package chash
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_Lazy(t *testing.T) {
+	newRings := func(t *testing.T) (lazy, eager CHash) {
+		var err error
+		lazy, err = New(Config{
+			PartitionCount:    5000,
+			ReplicationFactor: 3,
+			Lazy:              true,
+		})
+		require.NoError(t, err)
+		// unlimited overflow lets every partition take the closest members like the lazy mode
+		eager, err = New(Config{
+			PartitionCount:    5000,
+			ReplicationFactor: 3,
+			OverflowTolerance: math.MaxInt32,
+		})
+		require.NoError(t, err)
+		for _, h := range []CHash{lazy, eager} {
+			for i := 0; i < 8; i++ {
+				require.NoError(t, h.AddMembers(zonedMember{testMember{id: fmt.Sprint(i), cap: float64(1 + i%2)}, fmt.Sprint("z", i%2)}))
+			}
+		}
+		return
+	}
+	computed := func(h CHash) (n int) {
+		lp := h.(*cHash[Member]).snapshot.Load().lazy
+		for i := range lp.cells {
+			if lp.cells[i].Load() != nil {
+				n++
+			}
+		}
+		return
+	}
+	assertEqual := func(t *testing.T, lazy, eager CHash) {
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprint("k", i)
+			assert.Equal(t, eager.GetMembers(key), lazy.GetMembers(key), key)
+		}
+		for i := 0; i < lazy.PartitionCount(); i += 97 {
+			expected, err := eager.GetPartitionMembers(i)
+			require.NoError(t, err)
+			actual, err := lazy.GetPartitionMembers(i)
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		}
+	}
+
+	t.Run("on demand", func(t *testing.T) {
+		lazy, _ := newRings(t)
+		assert.Zero(t, computed(lazy))
+		ms := lazy.GetMembers("key")
+		assert.Len(t, ms, 3)
+		assert.Equal(t, 1, computed(lazy))
+		assert.Equal(t, ms, lazy.GetMembers("key"))
+		assert.Equal(t, 1, computed(lazy))
+	})
+	t.Run("matches eager", func(t *testing.T) {
+		lazy, eager := newRings(t)
+		assertEqual(t, lazy, eager)
+		assert.True(t, eager.Equal(lazy))
+		assert.Equal(t, eager.Checksum(), lazy.Checksum())
+		assert.Equal(t, lazy.PartitionCount(), computed(lazy))
+	})
+	t.Run("changes", func(t *testing.T) {
+		lazy, eager := newRings(t)
+		assertEqual(t, lazy, eager)
+		for _, h := range []CHash{lazy, eager} {
+			require.NoError(t, h.RemoveMembers("3"))
+			require.NoError(t, h.DrainMember("4"))
+			require.NoError(t, h.UpdateCapacity("5", 3))
+		}
+		assert.Zero(t, computed(lazy))
+		assertEqual(t, lazy, eager)
+		assert.Empty(t, lazy.PartitionsOwnedBy("4"))
+	})
+	t.Run("namespace", func(t *testing.T) {
+		lazy, eager := newRings(t)
+		lazyNs, err := lazy.Namespace(2)
+		require.NoError(t, err)
+		eagerNs, err := eager.Namespace(2)
+		require.NoError(t, err)
+		require.NoError(t, lazy.RemoveMembers("1"))
+		require.NoError(t, eager.RemoveMembers("1"))
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprint("k", i)
+			assert.Equal(t, eagerNs.GetMembers(key), lazyNs.GetMembers(key), key)
+		}
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		lazy, eager := newRings(t)
+		var wg sync.WaitGroup
+		var results = make([][][]Member, 4)
+		for w := range results {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					results[w] = append(results[w], lazy.GetMembers(fmt.Sprint("k", i)))
+				}
+			}(w)
+		}
+		wg.Wait()
+		for _, result := range results {
+			for i, ms := range result {
+				assert.Equal(t, eager.GetMembers(fmt.Sprint("k", i)), ms)
+			}
+		}
+	})
+	t.Run("config", func(t *testing.T) {
+		for _, c := range []Config{
+			{PartitionCount: 10, Lazy: true, LoadFactor: 1.25},
+			{PartitionCount: 10, Lazy: true, MaxPartitionsPerMember: 5},
+			{PartitionCount: 10, Lazy: true, Strategy: RendezvousStrategy{}},
+		} {
+			_, err := New(c)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func BenchmarkCHash_Lazy(b *testing.B) {
+	for _, lazy := range []bool{false, true} {
+		b.Run(fmt.Sprint("lazy=", lazy), func(b *testing.B) {
+			h, err := New(Config{
+				PartitionCount:    50000,
+				ReplicationFactor: 3,
+				Lazy:              lazy,
+			})
+			require.NoError(b, err)
+			for i := 0; i < 30; i++ {
+				require.NoError(b, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, h.UpdateCapacity("n0", float64(1+i%2)))
+				h.GetMembers("key")
+			}
+		})
+	}
+}
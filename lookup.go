@@ -0,0 +1,247 @@
+package chash
+
+import (
+	"math"
+
+	"golang.org/x/exp/slices"
+)
+
+func (c *cHash[M]) GetPrimary(key string) (m M, ok bool) {
+	ms := c.GetMembers(key)
+	if len(ms) == 0 {
+		return
+	}
+	return ms[0], true
+}
+
+// HashRange is the set of key hashes Lo, Lo+Step, Lo+2*Step... not greater than Hi
+// Hi is inclusive, so the range can end with the largest hash
+type HashRange struct {
+	Lo, Hi, Step uint64
+}
+
+// Contains checks whether the hash belongs to the range
+func (r HashRange) Contains(h uint64) bool {
+	return h >= r.Lo && h <= r.Hi && (h-r.Lo)%r.Step == 0
+}
+
+func (c *cHash[M]) HashRangesForPartition(partId int) []HashRange {
+	n := uint64(c.PartitionCount())
+	if partId < 0 || uint64(partId) >= n {
+		return nil
+	}
+	lo := uint64(partId)
+	// the last hash of the partition is the largest one with the same remainder
+	return []HashRange{{Lo: lo, Hi: lo + (math.MaxUint64-lo)/n*n, Step: n}}
+}
+
+func (c *cHash[M]) GetMembersE(key string) ([]M, error) {
+	ms := c.GetMembers(key)
+	if len(ms) == 0 {
+		return nil, ErrNoMembers
+	}
+	return ms, nil
+}
+
+func (c *cHash[M]) GetMembersInto(key string, buf []M) []M {
+	return append(buf[:0], c.GetMembers(key)...)
+}
+
+func (c *cHash[M]) GetMembersWithPartition(key string) (int, []M) {
+	s := c.snapshot.Load()
+	partId := s.partitionString(key)
+	return partId, s.members(partId)
+}
+
+func (c *cHash[M]) GetMembersPrevious(key string) []M {
+	s := c.snapshot.Load()
+	if s.previous != nil {
+		s = s.previous
+	}
+	return s.members(s.partitionString(key))
+}
+
+func (c *cHash[M]) GetWriteQuorum(key string, w int) []M {
+	return quorum(c.GetMembers(key), w)
+}
+
+func (c *cHash[M]) GetReadQuorum(key string, r int) []M {
+	return quorum(c.GetMembers(key), r)
+}
+
+func (c *cHash[M]) QuorumSatisfiable(w, r int) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rf := c.config.ReplicationFactor
+	return w > 0 && r > 0 && w <= rf && r <= rf && w+r > rf
+}
+
+// quorum returns the first n members, capacity is limited so appending to the result doesn't change the ring
+func quorum[M Member](ms []M, n int) []M {
+	if n <= 0 {
+		return nil
+	}
+	if n > len(ms) {
+		n = len(ms)
+	}
+	return ms[:n:n]
+}
+
+func (c *cHash[M]) GetMembersMany(keys []string) [][]M {
+	var (
+		s      = c.snapshot.Load()
+		result = make([][]M, len(keys))
+		buf    []byte
+	)
+	for i, key := range keys {
+		if ms, ok := c.pinned(key); ok {
+			result[i] = ms
+			continue
+		}
+		buf = append(buf[:0], key...)
+		result[i] = s.members(s.partition(buf))
+	}
+	return result
+}
+
+func (c *cHash[M]) GetMembersN(key string, n int) []M {
+	if n <= 0 {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	partId := c.getPartition(key)
+	ms := c.snapshot.Load().members(partId)
+	if n <= len(ms) {
+		return slices.Clone(ms[:n])
+	}
+	return c.appendNext(slices.Clone(ms), c.partitionHashes[partId], n, nil)
+}
+
+func (c *cHash[M]) GetMembersExcluding(key string, exclude ...string) []M {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	partId := c.getPartition(key)
+	var result []M
+	for _, m := range c.snapshot.Load().members(partId) {
+		if !slices.Contains(exclude, m.Id()) {
+			result = append(result, m)
+		}
+	}
+	return c.appendNext(result, c.partitionHashes[partId], c.effectiveReplicationFactor(), exclude)
+}
+
+// appendNext appends distinct members following the hash on the ring until result has n members
+// skip contains ids that must not be added
+func (c *cHash[M]) appendNext(result []M, h uint64, n int, skip []string) []M {
+	var available = c.placeableCount()
+	for _, id := range skip {
+		if _, ok := c.members[id]; ok && c.isPlaceable(id) {
+			available--
+		}
+	}
+	if n > available {
+		n = available
+	}
+	var contains = func(id string) bool {
+		if !c.isPlaceable(id) || slices.Contains(skip, id) {
+			return true
+		}
+		for _, m := range result {
+			if m.Id() == id {
+				return true
+			}
+		}
+		return false
+	}
+	if c.membersSet.Len() == 0 {
+		// a Strategy is used, so there is no ring: take members in the id order starting from the hash
+		ids := c.sortedIds()
+		for i := 0; i < len(ids) && len(result) < n; i++ {
+			id := ids[(int(h%uint64(len(ids)))+i)%len(ids)]
+			if !contains(id) {
+				result = append(result, c.members[id])
+			}
+		}
+		return result
+	}
+	idx := c.membersSet.search(h)
+	for i := 0; i < c.membersSet.Len() && len(result) < n; i++ {
+		if pos := (idx + i) % c.membersSet.Len(); !contains(c.membersSet.id(pos)) {
+			result = append(result, c.membersSet.member(pos))
+		}
+	}
+	return result
+}
+
+func (c *cHash[M]) Successor(key string) (M, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.successor(c.keyPosition(key))
+}
+
+func (c *cHash[M]) Predecessor(key string) (M, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.predecessor(c.keyPosition(key))
+}
+
+func (c *cHash[M]) KeyPosition(key string) uint64 {
+	return c.keyPosition(key)
+}
+
+func (c *cHash[M]) MemberPositions(id string) []uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ref := c.membersSet.ref(id)
+	if ref < 0 {
+		return nil
+	}
+	var positions []uint64
+	for i, r := range c.membersSet.refs {
+		if r == ref {
+			positions = append(positions, c.membersSet.hashes[i])
+		}
+	}
+	return positions
+}
+
+// keyPosition returns the position of the key on the ring of virtual members
+func (c *cHash[M]) keyPosition(key string) uint64 {
+	return sum64String(c.hasher, key)
+}
+
+// successor returns the first member at or after the position h
+func (c *cHash[M]) successor(h uint64) (m M, ok bool) {
+	if len(c.members) == 0 {
+		return
+	}
+	if c.membersSet.Len() == 0 {
+		// a Strategy is used, so there is no ring: take members in the id order like appendNext does
+		ids := c.sortedIds()
+		return c.members[ids[h%uint64(len(ids))]], true
+	}
+	return c.membersSet.member(c.membersSet.search(h) % c.membersSet.Len()), true
+}
+
+// predecessor returns the nearest member before the position h which differs from the successor of h
+func (c *cHash[M]) predecessor(h uint64) (m M, ok bool) {
+	if len(c.members) == 0 {
+		return
+	}
+	if c.membersSet.Len() == 0 {
+		ids := c.sortedIds()
+		return c.members[ids[(h%uint64(len(ids))+uint64(len(ids))-1)%uint64(len(ids))]], true
+	}
+	var (
+		n    = c.membersSet.Len()
+		idx  = c.membersSet.search(h) % n
+		next = c.membersSet.id(idx)
+	)
+	for i := 1; i < n; i++ {
+		if pos := (idx - i + n) % n; c.membersSet.id(pos) != next {
+			return c.membersSet.member(pos), true
+		}
+	}
+	return c.membersSet.member(idx), true
+}
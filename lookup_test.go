@@ -0,0 +1,461 @@
+package chash
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+func TestCHash_GetPrimary(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	_, ok := h.GetPrimary("key")
+	assert.False(t, ok)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i)
+		m, ok := h.GetPrimary(key)
+		require.True(t, ok)
+		assert.Equal(t, h.GetMembers(key)[0], m)
+	}
+}
+
+func TestCHash_GetMembersE(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	ms, err := h.GetMembersE("key")
+	assert.Equal(t, ErrNoMembers, err)
+	assert.Nil(t, ms)
+
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	ms, err = h.GetMembersE("key")
+	require.NoError(t, err)
+	assert.Equal(t, h.GetMembers("key"), ms)
+	assert.Len(t, ms, 2)
+
+	require.NoError(t, h.RemoveMembers("1", "2", "3"))
+	_, err = h.GetMembersE("key")
+	assert.Equal(t, ErrNoMembers, err)
+}
+
+func TestCHash_ReplicaOrder(t *testing.T) {
+	for _, strategy := range []Strategy{nil, RendezvousStrategy{}, MaglevStrategy{}} {
+		t.Run(fmt.Sprintf("%T", strategy), func(t *testing.T) {
+			var members []Member
+			for i := 0; i < 7; i++ {
+				members = append(members, testMember{id: fmt.Sprint(i), cap: float64(1 + i%3)})
+			}
+			var rings []CHash
+			for _, order := range [][]Member{members, {members[6], members[3], members[0], members[5], members[1], members[4], members[2]}} {
+				h, err := New(Config{
+					PartitionCount:    50,
+					ReplicationFactor: 3,
+					Strategy:          strategy,
+				})
+				require.NoError(t, err)
+				for _, m := range order {
+					require.NoError(t, h.AddMembers(m))
+				}
+				rings = append(rings, h)
+			}
+			// every key of a partition gets its members in the same order regardless of the order members were added
+			var byPartition = make(map[int][]Member)
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprint("k", i)
+				partition, ms := rings[0].GetMembersWithPartition(key)
+				if prev, ok := byPartition[partition]; ok {
+					assert.Equal(t, prev, ms)
+				}
+				byPartition[partition] = ms
+				assert.Equal(t, ms, rings[1].GetMembers(key))
+				primary, ok := rings[1].GetPrimary(key)
+				require.True(t, ok)
+				assert.Equal(t, ms[0], primary)
+			}
+			rings[0].Distribute()
+			for partition, ms := range byPartition {
+				actual, err := rings[0].GetPartitionMembers(partition)
+				require.NoError(t, err)
+				assert.Equal(t, ms, actual)
+			}
+		})
+	}
+}
+
+func TestCHash_GetMembersPrevious(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, h.GetMembersPrevious("key"))
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	assert.Empty(t, h.GetMembersPrevious("key"))
+
+	before := h.Clone()
+	require.NoError(t, h.Reconfigure([]Member{testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}, testMember{id: "4", cap: 1}}))
+	var moved int
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("k", i)
+		assert.Equal(t, before.GetMembers(key), h.GetMembersPrevious(key))
+		if !slices.Equal(memberIds(before.GetMembers(key)), memberIds(h.GetMembers(key))) {
+			moved++
+		}
+	}
+	assert.NotZero(t, moved)
+
+	t.Run("next change", func(t *testing.T) {
+		current := h.Clone()
+		require.NoError(t, h.SetPartitionCount(64))
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprint("k", i)
+			assert.Equal(t, current.GetMembers(key), h.GetMembersPrevious(key))
+		}
+	})
+}
+
+func TestCHash_GetMembersN(t *testing.T) {
+	for _, strategy := range []Strategy{nil, RendezvousStrategy{}} {
+		t.Run(fmt.Sprintf("%T", strategy), func(t *testing.T) {
+			h, err := New(Config{
+				PartitionCount:    100,
+				ReplicationFactor: 2,
+				Strategy:          strategy,
+			})
+			require.NoError(t, err)
+			assert.Empty(t, h.GetMembersN("key", 3))
+			for i := 0; i < 5; i++ {
+				require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+			}
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprint("k", i)
+				members := h.GetMembers(key)
+				assert.Equal(t, members[:1], h.GetMembersN(key, 1))
+				assert.Equal(t, members, h.GetMembersN(key, 2))
+				for _, n := range []int{3, 5, 10} {
+					ms := h.GetMembersN(key, n)
+					if n > 5 {
+						require.Len(t, ms, 5)
+					} else {
+						require.Len(t, ms, n)
+					}
+					assert.Equal(t, members, ms[:2])
+					var ids = map[string]bool{}
+					for _, m := range ms {
+						ids[m.Id()] = true
+					}
+					assert.Len(t, ids, len(ms))
+				}
+			}
+		})
+	}
+}
+
+func TestCHash_GetMembersExcluding(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i)
+		members := h.GetMembers(key)
+		assert.Equal(t, members, h.GetMembersExcluding(key))
+		assert.Equal(t, members, h.GetMembersExcluding(key, "unknown"))
+
+		ms := h.GetMembersExcluding(key, members[0].Id())
+		require.Len(t, ms, 3)
+		assert.Equal(t, members[1:], ms[:2])
+		var ids = map[string]bool{}
+		for _, m := range ms {
+			ids[m.Id()] = true
+		}
+		assert.Len(t, ids, 3)
+		assert.False(t, ids[members[0].Id()])
+		assert.Equal(t, ms, h.GetMembersExcluding(key, members[0].Id()))
+
+		assert.Len(t, h.GetMembersExcluding(key, "0", "1", "2"), 2)
+	}
+}
+
+func TestCHash_GetMembersMany(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	var keys = make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprint("k", i)
+	}
+	result := h.GetMembersMany(keys)
+	require.Len(t, result, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, h.GetMembers(key), result[i])
+	}
+}
+
+func BenchmarkCHash_GetMembersMany(b *testing.B) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(b, err)
+	for i := 0; i < 30; i++ {
+		require.NoError(b, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+	}
+	var keys = make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprint("k", i)
+	}
+	b.Run("loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				h.GetMembers(key)
+			}
+		}
+	})
+	b.Run("many", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.GetMembersMany(keys)
+		}
+	})
+}
+
+func TestCHash_GetMembersWithPartition(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i)
+		partId, ms := h.GetMembersWithPartition(key)
+		assert.Equal(t, h.GetPartition(key), partId)
+		assert.Equal(t, h.GetMembers(key), ms)
+	}
+}
+
+func TestCHash_GetMembersInto(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, h.GetMembersInto("key", nil))
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	var buf = make([]Member, 0, 1)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i)
+		buf = h.GetMembersInto(key, buf)
+		assert.Equal(t, h.GetMembers(key), buf)
+	}
+	buf[0] = nil
+	assert.NotNil(t, h.GetMembers("k99")[0])
+}
+
+func BenchmarkCHash_GetMembersInto(b *testing.B) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(b, err)
+	for i := 0; i < 30; i++ {
+		require.NoError(b, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+	}
+	var keys = make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprint("k", i)
+	}
+	var buf = make([]Member, 0, 3)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = h.GetMembersInto(keys[i%len(keys)], buf)
+	}
+}
+
+func TestCHash_Quorum(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, h.GetWriteQuorum("key", 2))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+	}
+	t.Run("selection", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint("k", i)
+			members := h.GetMembers(key)
+			assert.Equal(t, members[:2], h.GetWriteQuorum(key, 2))
+			assert.Equal(t, members[:1], h.GetReadQuorum(key, 1))
+			assert.Equal(t, members, h.GetWriteQuorum(key, 5))
+			assert.Empty(t, h.GetReadQuorum(key, 0))
+		}
+	})
+	t.Run("satisfiable", func(t *testing.T) {
+		assert.True(t, h.QuorumSatisfiable(2, 2))
+		assert.True(t, h.QuorumSatisfiable(3, 1))
+		assert.False(t, h.QuorumSatisfiable(2, 1))
+		assert.False(t, h.QuorumSatisfiable(4, 1))
+		assert.False(t, h.QuorumSatisfiable(0, 3))
+	})
+	t.Run("overlap", func(t *testing.T) {
+		for w := 1; w <= 3; w++ {
+			for r := 1; r <= 3; r++ {
+				if !h.QuorumSatisfiable(w, r) {
+					continue
+				}
+				for i := 0; i < 100; i++ {
+					members := h.GetMembers(fmt.Sprint("k", i))
+					// the worst case read set is the last r replicas
+					var overlap bool
+					for _, m := range h.GetWriteQuorum(fmt.Sprint("k", i), w) {
+						for _, rm := range members[len(members)-r:] {
+							overlap = overlap || m.Id() == rm.Id()
+						}
+					}
+					assert.True(t, overlap, "w=%d r=%d", w, r)
+				}
+			}
+		}
+	})
+}
+
+func TestCHash_SuccessorPredecessor(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 2,
+		MultiplyFactor:    3,
+	})
+	require.NoError(t, err)
+	_, ok := h.Successor("key")
+	assert.False(t, ok)
+	_, ok = h.Predecessor("key")
+	assert.False(t, ok)
+
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}))
+	s, _ := h.Successor("key")
+	p, _ := h.Predecessor("key")
+	assert.Equal(t, "1", s.Id())
+	assert.Equal(t, "1", p.Id())
+
+	require.NoError(t, h.AddMembers(testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}, testMember{id: "4", cap: 1}))
+	t.Run("distinct", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint("k", i)
+			s, ok := h.Successor(key)
+			require.True(t, ok)
+			p, ok := h.Predecessor(key)
+			require.True(t, ok)
+			assert.NotEqual(t, s.Id(), p.Id())
+		}
+	})
+	t.Run("walk", func(t *testing.T) {
+		c := h.(*cHash[Member])
+		var (
+			pos  = c.keyPosition("key")
+			seen = map[string]struct{}{}
+		)
+		for i := 0; i < c.membersSet.Len(); i++ {
+			m, _ := c.successor(pos)
+			seen[m.Id()] = struct{}{}
+			// step right after the virtual member found
+			pos = c.membersSet.hashes[c.membersSet.search(pos)%c.membersSet.Len()] + 1
+		}
+		assert.Len(t, seen, 4)
+	})
+}
+
+func TestCHash_Positions(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 2,
+		MultiplyFactor:    5,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}))
+	assert.Equal(t, h.KeyPosition("key"), h.KeyPosition("key"))
+	assert.NotEqual(t, h.KeyPosition("key"), h.KeyPosition("key2"))
+
+	positions := h.MemberPositions("1")
+	assert.Len(t, positions, 5)
+	assert.True(t, slices.IsSorted(positions))
+	assert.Len(t, h.MemberPositions("2"), 10)
+	assert.Nil(t, h.MemberPositions("3"))
+
+	// the key lands right before a virtual member of its successor
+	s, _ := h.Successor("key")
+	var next *uint64
+	for _, id := range []string{"1", "2"} {
+		for _, p := range h.MemberPositions(id) {
+			if p >= h.KeyPosition("key") && (next == nil || p < *next) {
+				p := p
+				next = &p
+			}
+		}
+	}
+	if next != nil {
+		assert.Contains(t, h.MemberPositions(s.Id()), *next)
+	}
+}
+
+func TestCHash_HashRangesForPartition(t *testing.T) {
+	for _, count := range []uint64{10, 64, 1000} {
+		t.Run(fmt.Sprint("partitions ", count), func(t *testing.T) {
+			h, err := New(Config{PartitionCount: count})
+			require.NoError(t, err)
+			assert.Nil(t, h.HashRangesForPartition(-1))
+			assert.Nil(t, h.HashRangesForPartition(int(count)))
+
+			// ranges cover the space exactly once when their first hashes are the remainders 0..count-1 and they end at the largest hashes
+			var ranges []HashRange
+			var covered = make([]bool, count)
+			for i := 0; i < int(count); i++ {
+				rs := h.HashRangesForPartition(i)
+				for _, r := range rs {
+					require.Equal(t, count, r.Step)
+					require.Less(t, r.Lo, count)
+					assert.False(t, covered[r.Lo])
+					covered[r.Lo] = true
+					assert.Greater(t, r.Hi, uint64(math.MaxUint64)-count)
+					assert.Equal(t, i, h.GetPartitionByHash(r.Lo))
+					assert.Equal(t, i, h.GetPartitionByHash(r.Hi))
+				}
+				ranges = append(ranges, rs...)
+			}
+			assert.NotContains(t, covered, false)
+
+			rnd := rand.New(rand.NewSource(1))
+			for _, hash := range []uint64{0, 1, count - 1, count, math.MaxUint64 - 1, math.MaxUint64, rnd.Uint64(), rnd.Uint64()} {
+				var found []int
+				for i, r := range ranges {
+					if r.Contains(hash) {
+						found = append(found, i)
+					}
+				}
+				assert.Equal(t, []int{h.GetPartitionByHash(hash)}, found)
+			}
+		})
+	}
+}
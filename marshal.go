@@ -0,0 +1,270 @@
+package chash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+var errInvalidData = errors.New("invalid ring data")
+
+// marshalVersion 2 added flags of members, 3 added position ids, 4 added standby members, data of previous versions is still decoded
+const marshalVersion = 4
+
+const (
+	// memberDrained is the member flag of drained members
+	memberDrained = 1 << iota
+	// memberPositionId is the member flag of members placed by the virtual keys of another id, the id follows flags
+	memberPositionId
+	// memberStandby is the member flag of standby members that are not promoted
+	memberStandby
+)
+
+// SerializableMember is a member restored by UnmarshalBinary and GobDecode
+// It keeps only id and capacity, so call Reconfigure with your own members if you need richer types
+type SerializableMember struct {
+	MemberId       string
+	MemberCapacity float64
+}
+
+func (m SerializableMember) Id() string {
+	return m.MemberId
+}
+
+func (m SerializableMember) Capacity() float64 {
+	return m.MemberCapacity
+}
+
+// ringState is the gob representation of the ring
+type ringState struct {
+	PartitionCount    uint64
+	ReplicationFactor int
+	MultiplyFactor    int
+	Members           []SerializableMember
+	// Drained are ids of drained members
+	Drained []string
+	// Standby are ids of standby members that are not promoted
+	Standby []string
+	// PositionIds are ids whose virtual keys give positions of members replaced by ReplaceMember or MoveMember
+	PositionIds map[string]string
+	// placement is the config only decoded by UnmarshalProto, other encodings keep the current one
+	placement *placementConfig
+}
+
+func (c *cHash[M]) MarshalBinary() (data []byte, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var ids = c.sortedIds()
+	data = append(data, marshalVersion)
+	data = binary.AppendUvarint(data, c.config.PartitionCount)
+	data = binary.AppendUvarint(data, uint64(c.config.ReplicationFactor))
+	data = binary.AppendUvarint(data, uint64(c.config.MultiplyFactor))
+	data = binary.AppendUvarint(data, uint64(len(ids)))
+	for _, id := range ids {
+		data = binary.AppendUvarint(data, uint64(len(id)))
+		data = append(data, id...)
+		data = binary.BigEndian.AppendUint64(data, math.Float64bits(c.weight(c.members[id])))
+		var flags byte
+		if _, drained := c.drained[id]; drained {
+			flags |= memberDrained
+		}
+		if _, standby := c.standby[id]; standby {
+			flags |= memberStandby
+		}
+		positionId, moved := c.positionIds[id]
+		if moved {
+			flags |= memberPositionId
+		}
+		data = append(data, flags)
+		if moved {
+			data = binary.AppendUvarint(data, uint64(len(positionId)))
+			data = append(data, positionId...)
+		}
+	}
+	return data, nil
+}
+
+func (c *cHash[M]) UnmarshalBinary(data []byte) (err error) {
+	if len(data) == 0 || data[0] < 1 || data[0] > marshalVersion {
+		return errInvalidData
+	}
+	var version = data[0]
+	data = data[1:]
+	var readUvarint = func() uint64 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			err = errInvalidData
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+	var state ringState
+	state.PartitionCount = readUvarint()
+	state.ReplicationFactor = int(readUvarint())
+	state.MultiplyFactor = int(readUvarint())
+	count := readUvarint()
+	if err != nil {
+		return
+	}
+	if count > uint64(len(data)) {
+		return errInvalidData
+	}
+	state.Members = make([]SerializableMember, 0, count)
+	for i := uint64(0); i < count; i++ {
+		l := readUvarint()
+		if err != nil {
+			return
+		}
+		if uint64(len(data)) < l+8 {
+			return errInvalidData
+		}
+		state.Members = append(state.Members, SerializableMember{
+			MemberId:       string(data[:l]),
+			MemberCapacity: math.Float64frombits(binary.BigEndian.Uint64(data[l:])),
+		})
+		data = data[l+8:]
+		if version >= 2 {
+			if len(data) == 0 {
+				return errInvalidData
+			}
+			var flags, id = data[0], state.Members[len(state.Members)-1].MemberId
+			data = data[1:]
+			if flags&memberDrained != 0 {
+				state.Drained = append(state.Drained, id)
+			}
+			if flags&memberStandby != 0 && version >= 4 {
+				state.Standby = append(state.Standby, id)
+			}
+			if flags&memberPositionId != 0 && version >= 3 {
+				l := readUvarint()
+				if err != nil {
+					return
+				}
+				if uint64(len(data)) < l {
+					return errInvalidData
+				}
+				if state.PositionIds == nil {
+					state.PositionIds = make(map[string]string)
+				}
+				state.PositionIds[id] = string(data[:l])
+				data = data[l:]
+			}
+		}
+	}
+	return c.restore(state)
+}
+
+func (c *cHash[M]) GobEncode() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var state = ringState{
+		PartitionCount:    c.config.PartitionCount,
+		ReplicationFactor: c.config.ReplicationFactor,
+		MultiplyFactor:    c.config.MultiplyFactor,
+		Members:           make([]SerializableMember, 0, len(c.members)),
+	}
+	for _, id := range c.sortedIds() {
+		state.Members = append(state.Members, SerializableMember{
+			MemberId:       id,
+			MemberCapacity: c.weight(c.members[id]),
+		})
+		if _, drained := c.drained[id]; drained {
+			state.Drained = append(state.Drained, id)
+		}
+		if _, standby := c.standby[id]; standby {
+			state.Standby = append(state.Standby, id)
+		}
+	}
+	if len(c.positionIds) != 0 {
+		state.PositionIds = maps.Clone(c.positionIds)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *cHash[M]) GobDecode(data []byte) error {
+	var state ringState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+		return err
+	}
+	return c.restore(state)
+}
+
+// restore replaces config and members of the ring with decoded ones
+func (c *cHash[M]) restore(state ringState) (err error) {
+	var members = make([]M, 0, len(state.Members))
+	var ids = make(map[string]struct{}, len(state.Members))
+	for _, sm := range state.Members {
+		if err = validateMember(sm); err != nil {
+			return
+		}
+		if _, ok := ids[sm.MemberId]; ok {
+			return ErrMemberExists
+		}
+		ids[sm.MemberId] = struct{}{}
+		m, ok := any(sm).(M)
+		if !ok {
+			return fmt.Errorf("can't decode members into %T", m)
+		}
+		members = append(members, m)
+	}
+	for _, id := range append(slices.Clone(state.Drained), state.Standby...) {
+		if _, ok := ids[id]; !ok {
+			return ErrMemberNotExists
+		}
+	}
+	for id := range state.PositionIds {
+		if _, ok := ids[id]; !ok {
+			return ErrMemberNotExists
+		}
+	}
+
+	c.lock()
+	defer c.unlock()
+	config := c.config
+	if config.Hasher == nil {
+		config.Hasher = defaultHasher{}
+	}
+	config.PartitionCount = state.PartitionCount
+	config.ReplicationFactor = state.ReplicationFactor
+	config.MultiplyFactor = state.MultiplyFactor
+	if p := state.placement; p != nil {
+		config.Seed = p.Seed
+		config.LoadFactor = p.LoadFactor
+		config.OverflowTolerance = p.OverflowTolerance
+		config.MaxPartitionsPerMember = p.MaxPartitionsPerMember
+		config.Lazy = p.Lazy
+		config.NormalizeCapacity = p.NormalizeCapacity
+		config.MaxVirtualMembers = p.MaxVirtualMembers
+	}
+	if err = checkConfig(config); err != nil {
+		return
+	}
+	if config.RequireFullReplication && len(members)-len(state.Drained) < config.ReplicationFactor {
+		return ErrInsufficientMembers
+	}
+	// everything is validated, so the ring is changed and published only once
+	c.config = config
+	c.membersSet = c.membersSet.reset()
+	c.initState()
+	for _, id := range state.Drained {
+		c.drained[id] = struct{}{}
+	}
+	for _, id := range state.Standby {
+		c.standby[id] = struct{}{}
+	}
+	for id, positionId := range state.PositionIds {
+		c.positionIds[id] = positionId
+	}
+	return c.addMembers(members...)
+}
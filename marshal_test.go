@@ -0,0 +1,178 @@
+package chash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_MarshalBinary(t *testing.T) {
+	h1, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+		MultiplyFactor:    500,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h1.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}))
+	require.NoError(t, h1.UpdateCapacity("3", 1.5))
+	data, err := h1.MarshalBinary()
+	require.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.UnmarshalBinary(data))
+		assert.Equal(t, h1.PartitionCount(), h2.PartitionCount())
+		assert.Equal(t, 3, h2.MemberCount())
+		for i := 0; i < h1.PartitionCount(); i++ {
+			ms1, _ := h1.GetPartitionMembers(i)
+			ms2, _ := h2.GetPartitionMembers(i)
+			require.Len(t, ms2, len(ms1))
+			for j := range ms1 {
+				assert.Equal(t, ms1[j].Id(), ms2[j].Id())
+			}
+		}
+		m, ok := h2.GetMember("3")
+		require.True(t, ok)
+		assert.Equal(t, 1.5, m.Capacity())
+		data2, err := h2.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, data, data2)
+	})
+	t.Run("invalid data", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		assert.Error(t, h2.UnmarshalBinary(nil))
+		assert.Error(t, h2.UnmarshalBinary(data[:len(data)-1]))
+	})
+	t.Run("invalid capacity", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(testMember{id: "a", cap: 1}))
+		partitions, version := h2.Partitions(), h2.Version()
+		for _, capacity := range []float64{math.Inf(1), math.NaN(), -1} {
+			invalid := bytes.Clone(data)
+			// the capacity of the last member is followed by its flags
+			binary.BigEndian.PutUint64(invalid[len(invalid)-9:], math.Float64bits(capacity))
+			assert.Equal(t, ErrInvalidCapacity, h2.UnmarshalBinary(invalid))
+			assert.Equal(t, 1, h2.MemberCount())
+			assert.Equal(t, partitions, h2.Partitions())
+			assert.Equal(t, version, h2.Version())
+		}
+	})
+	t.Run("published once", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.UnmarshalBinary(data))
+		assert.Equal(t, uint64(1), h2.Version())
+	})
+	t.Run("drained members", func(t *testing.T) {
+		h3 := h1.Clone()
+		require.NoError(t, h3.DrainMember("2"))
+		data, err := h3.MarshalBinary()
+		require.NoError(t, err)
+		h4, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h4.UnmarshalBinary(data))
+		assert.Empty(t, h4.PartitionsOwnedBy("2"))
+		assert.True(t, h3.Equal(h4))
+	})
+	t.Run("standby members", func(t *testing.T) {
+		h3 := h1.Clone()
+		require.NoError(t, h3.AddMembers(standbyMember{testMember{id: "s", cap: 1}}))
+		data, err := h3.MarshalBinary()
+		require.NoError(t, err)
+		h4, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h4.UnmarshalBinary(data))
+		assert.Empty(t, h4.PartitionsOwnedBy("s"))
+		assert.True(t, h3.Equal(h4))
+
+		require.NoError(t, h3.RemoveMembers("1", "2"))
+		require.NoError(t, h4.RemoveMembers("1", "2"))
+		assert.NotEmpty(t, h4.PartitionsOwnedBy("s"))
+		assert.True(t, h3.Equal(h4))
+	})
+	t.Run("replaced members", func(t *testing.T) {
+		h3 := h1.Clone()
+		require.NoError(t, h3.ReplaceMember("1", testMember{id: "4", cap: 1}))
+		require.NoError(t, h3.MoveMember("2", testMember{id: "5", cap: 2}))
+		data, err := h3.MarshalBinary()
+		require.NoError(t, err)
+		h4, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h4.UnmarshalBinary(data))
+		assert.True(t, h3.Equal(h4))
+		assert.Equal(t, h3.MemberPositions("4"), h4.MemberPositions("4"))
+
+		var buf bytes.Buffer
+		require.NoError(t, gob.NewEncoder(&buf).Encode(h3))
+		h5, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, gob.NewDecoder(&buf).Decode(h5))
+		assert.True(t, h3.Equal(h5))
+	})
+	t.Run("version 1", func(t *testing.T) {
+		// version 1 has no member flags
+		var v1 = []byte{1}
+		v1 = binary.AppendUvarint(v1, 10)
+		v1 = binary.AppendUvarint(v1, 1)
+		v1 = binary.AppendUvarint(v1, 10)
+		v1 = binary.AppendUvarint(v1, 1)
+		v1 = binary.AppendUvarint(v1, 1)
+		v1 = append(v1, 'a')
+		v1 = binary.BigEndian.AppendUint64(v1, math.Float64bits(2))
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.UnmarshalBinary(v1))
+		m, ok := h2.GetMember("a")
+		require.True(t, ok)
+		assert.Equal(t, float64(2), m.Capacity())
+		assert.Len(t, h2.PartitionsOwnedBy("a"), 10)
+	})
+	t.Run("generic members", func(t *testing.T) {
+		h2, err := NewG[testMember](Config{PartitionCount: 10})
+		require.NoError(t, err)
+		assert.Error(t, h2.UnmarshalBinary(data))
+	})
+}
+
+func TestCHash_GobEncode(t *testing.T) {
+	h1, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h1.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}))
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(h1))
+	h2, err := New(Config{PartitionCount: 10})
+	require.NoError(t, err)
+	require.NoError(t, gob.NewDecoder(&buf).Decode(h2))
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i)
+		ms1, ms2 := h1.GetMembers(key), h2.GetMembers(key)
+		require.Len(t, ms2, len(ms1))
+		for j := range ms1 {
+			assert.Equal(t, ms1[j].Id(), ms2[j].Id())
+			assert.IsType(t, SerializableMember{}, ms2[j])
+		}
+	}
+
+	t.Run("drained members", func(t *testing.T) {
+		require.NoError(t, h1.DrainMember("3"))
+		var buf bytes.Buffer
+		require.NoError(t, gob.NewEncoder(&buf).Encode(h1))
+		require.NoError(t, gob.NewDecoder(&buf).Decode(h2))
+		assert.Empty(t, h2.PartitionsOwnedBy("3"))
+		assert.True(t, h1.Equal(h2))
+	})
+}
@@ -0,0 +1,94 @@
+package chash
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"golang.org/x/exp/slices"
+)
+
+// Namespace is a view of a ring storing members as the Member interface
+type Namespace = NamespaceG[Member]
+
+// NamespaceG is a view of a ring with its own replication factor, see CHashG.Namespace
+type NamespaceG[M Member] interface {
+	// GetMembers returns list of members for given key
+	// The returned slice is shared with the namespace and must not be modified
+	GetMembers(key string) []M
+	// GetMembersBytes works like GetMembers but accepts the key as bytes
+	GetMembersBytes(key []byte) []M
+	// GetPartition returns partition number for given key, it's the same as the ring returns
+	GetPartition(key string) int
+	// GetPartitionMembers return a copy of members by partition number
+	GetPartitionMembers(partId int) ([]M, error)
+	// Partitions returns the current partitions table of the namespace, it must not be modified
+	Partitions() [][]M
+	// ReplicationFactor returns the replication factor of the namespace
+	ReplicationFactor() int
+}
+
+type namespace[M Member] struct {
+	rf       int
+	snapshot atomic.Pointer[snapshot[M]]
+}
+
+func (c *cHash[M]) Namespace(rf int) (NamespaceG[M], error) {
+	if rf < 1 {
+		return nil, fmt.Errorf("replication factor must be greater or equal 1")
+	}
+	c.lock()
+	defer c.unlock()
+	if err := c.checkReplication(c.availableCount(), rf); err != nil {
+		return nil, err
+	}
+	ns := &namespace[M]{rf: rf}
+	if c.idleStandby != 0 && rf > c.placeableCount() {
+		// standby members replace missing members of the namespace too, so the ring may get them as well
+		c.namespaces = append(c.namespaces, ns)
+		c.distributeNow()
+		return ns, nil
+	}
+	if c.config.Lazy {
+		ns.snapshot.Store(c.newLazySnapshot(c.lazyPartitions(rf)))
+		c.namespaces = append(c.namespaces, ns)
+		return ns, nil
+	}
+	partitions, err := c.buildPartitions(context.Background(), rf)
+	if err != nil {
+		return nil, err
+	}
+	ns.snapshot.Store(c.newSnapshot(partitions))
+	c.namespaces = append(c.namespaces, ns)
+	return ns, nil
+}
+
+func (ns *namespace[M]) GetMembers(key string) []M {
+	s := ns.snapshot.Load()
+	return s.members(s.partitionString(key))
+}
+
+func (ns *namespace[M]) GetMembersBytes(key []byte) []M {
+	s := ns.snapshot.Load()
+	return s.members(s.partition(key))
+}
+
+func (ns *namespace[M]) GetPartition(key string) int {
+	return ns.snapshot.Load().partitionString(key)
+}
+
+func (ns *namespace[M]) GetPartitionMembers(partId int) ([]M, error) {
+	s := ns.snapshot.Load()
+	if partId < 0 || partId >= len(s.partitions) {
+		return nil, ErrPartitionNotExists
+	}
+	return slices.Clone(s.members(partId)), nil
+}
+
+func (ns *namespace[M]) Partitions() [][]M {
+	return ns.snapshot.Load().table()
+}
+
+func (ns *namespace[M]) ReplicationFactor() int {
+	return ns.rf
+}
@@ -0,0 +1,74 @@
+package chash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_Namespace(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	_, err = h.Namespace(0)
+	assert.Error(t, err)
+
+	ns, err := h.Namespace(3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, ns.ReplicationFactor())
+	assert.Empty(t, ns.GetMembers("key"))
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint(i), cap: 1}))
+	}
+	t.Run("different replication factor", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint("k", i)
+			assert.Len(t, h.GetMembers(key), 2)
+			assert.Len(t, ns.GetMembers(key), 3)
+			assert.Len(t, ns.GetMembersBytes([]byte(key)), 3)
+			assert.Equal(t, h.GetPartition(key), ns.GetPartition(key))
+		}
+		assert.Len(t, ns.Partitions(), 100)
+	})
+	t.Run("membership changes", func(t *testing.T) {
+		require.NoError(t, h.RemoveMembers("0", "1", "2"))
+		for _, members := range ns.Partitions() {
+			assert.Len(t, members, 2)
+			for _, m := range members {
+				assert.NotContains(t, []string{"0", "1", "2"}, m.Id())
+			}
+		}
+	})
+	t.Run("partition members", func(t *testing.T) {
+		members, err := ns.GetPartitionMembers(1)
+		require.NoError(t, err)
+		assert.Equal(t, ns.Partitions()[1], members)
+		_, err = ns.GetPartitionMembers(100)
+		assert.Equal(t, ErrPartitionNotExists, err)
+	})
+}
+
+func TestCHash_NamespaceFullReplication(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:         100,
+		ReplicationFactor:      2,
+		RequireFullReplication: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	_, err = h.Namespace(5)
+	assert.Equal(t, ErrInsufficientMembers, err)
+
+	require.NoError(t, h.AddMembers(testMember{id: "3", cap: 1}))
+	ns, err := h.Namespace(3)
+	require.NoError(t, err)
+	assert.Len(t, ns.GetMembers("key"), 3)
+	// the ring needs only 2 members, but the namespace needs 3
+	assert.Equal(t, ErrInsufficientMembers, h.RemoveMembers("3"))
+	assert.Len(t, ns.GetMembers("key"), 3)
+}
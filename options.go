@@ -0,0 +1,146 @@
+package chash
+
+import "time"
+
+// Option configures the ring created by NewWithOptions
+type Option func(c *Config)
+
+// WithPartitionCount sets how many virtual partitions will be distributed by members
+func WithPartitionCount(partitionCount uint64) Option {
+	return func(c *Config) {
+		c.PartitionCount = partitionCount
+	}
+}
+
+// WithReplicationFactor sets how many members expected for GetMembers
+func WithReplicationFactor(replicationFactor int) Option {
+	return func(c *Config) {
+		c.ReplicationFactor = replicationFactor
+	}
+}
+
+// WithHasher sets the hasher, by default, will be used xxhash
+func WithHasher(hasher Hasher) Option {
+	return func(c *Config) {
+		c.Hasher = hasher
+	}
+}
+
+// WithVirtualNodes sets how many virtual members will be added to the hash ring for a member with capacity 1, see Config.MultiplyFactor
+func WithVirtualNodes(virtualNodes int) Option {
+	return func(c *Config) {
+		c.MultiplyFactor = virtualNodes
+	}
+}
+
+// WithLoadFactor sets Config.LoadFactor
+func WithLoadFactor(loadFactor float64) Option {
+	return func(c *Config) {
+		c.LoadFactor = loadFactor
+	}
+}
+
+// WithStrategy sets Config.Strategy
+func WithStrategy(strategy Strategy) Option {
+	return func(c *Config) {
+		c.Strategy = strategy
+	}
+}
+
+// WithParallel sets Config.Parallel
+func WithParallel(parallel bool) Option {
+	return func(c *Config) {
+		c.Parallel = parallel
+	}
+}
+
+// WithSeed sets Config.Seed
+func WithSeed(seed uint64) Option {
+	return func(c *Config) {
+		c.Seed = seed
+	}
+}
+
+// WithKeyHasher sets Config.KeyHasher
+func WithKeyHasher(hasher Hasher) Option {
+	return func(c *Config) {
+		c.KeyHasher = hasher
+	}
+}
+
+// WithOnRebalance sets Config.OnRebalance
+func WithOnRebalance(onRebalance func(changed []int)) Option {
+	return func(c *Config) {
+		c.OnRebalance = onRebalance
+	}
+}
+
+// WithRequireFullReplication sets Config.RequireFullReplication
+func WithRequireFullReplication(require bool) Option {
+	return func(c *Config) {
+		c.RequireFullReplication = require
+	}
+}
+
+// WithKeyCacheSize sets Config.KeyCacheSize
+func WithKeyCacheSize(size int) Option {
+	return func(c *Config) {
+		c.KeyCacheSize = size
+	}
+}
+
+// WithOverflowTolerance sets Config.OverflowTolerance
+func WithOverflowTolerance(tolerance float64) Option {
+	return func(c *Config) {
+		c.OverflowTolerance = tolerance
+	}
+}
+
+// WithMaxPartitionsPerMember sets Config.MaxPartitionsPerMember
+func WithMaxPartitionsPerMember(max int) Option {
+	return func(c *Config) {
+		c.MaxPartitionsPerMember = max
+	}
+}
+
+// WithLazy sets Config.Lazy
+func WithLazy(lazy bool) Option {
+	return func(c *Config) {
+		c.Lazy = lazy
+	}
+}
+
+// WithCoalesceWindow sets Config.CoalesceWindow
+func WithCoalesceWindow(window time.Duration) Option {
+	return func(c *Config) {
+		c.CoalesceWindow = window
+	}
+}
+
+// WithMaxReplicaChangePerPartition sets Config.MaxReplicaChangePerPartition
+func WithMaxReplicaChangePerPartition(max int) Option {
+	return func(c *Config) {
+		c.MaxReplicaChangePerPartition = max
+	}
+}
+
+// WithUnsafeStringHash sets Config.UnsafeStringHash
+func WithUnsafeStringHash(unsafe bool) Option {
+	return func(c *Config) {
+		c.UnsafeStringHash = unsafe
+	}
+}
+
+// WithNormalizeCapacity sets Config.NormalizeCapacity
+func WithNormalizeCapacity(normalize bool) Option {
+	return func(c *Config) {
+		c.NormalizeCapacity = normalize
+	}
+}
+
+// WithMaxVirtualMembers sets Config.MaxVirtualMembers
+func WithMaxVirtualMembers(max int) Option {
+	return func(c *Config) {
+		c.MaxVirtualMembers = max
+	}
+}
@@ -0,0 +1,76 @@
+package chash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_Options(t *testing.T) {
+	t.Run("compose", func(t *testing.T) {
+		h, err := NewWithOptions(
+			WithPartitionCount(50),
+			WithReplicationFactor(2),
+			WithHasher(fnvHasher{}),
+			WithVirtualNodes(10),
+			WithLoadFactor(1.25),
+			WithMaxPartitionsPerMember(50),
+		)
+		require.NoError(t, err)
+		c := h.(*cHash[Member]).config
+		assert.Equal(t, uint64(50), c.PartitionCount)
+		assert.Equal(t, 2, c.ReplicationFactor)
+		assert.Equal(t, fnvHasher{}, c.Hasher)
+		assert.Equal(t, 10, c.MultiplyFactor)
+		assert.Equal(t, 1.25, c.LoadFactor)
+		assert.Equal(t, 50, c.MaxPartitionsPerMember)
+
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+		assert.Equal(t, 20, h.(*cHash[Member]).membersSet.Len())
+		assert.Len(t, h.GetMembers("a"), 2)
+	})
+	t.Run("last option wins", func(t *testing.T) {
+		h, err := NewWithOptions(WithPartitionCount(10), WithPartitionCount(20))
+		require.NoError(t, err)
+		assert.Equal(t, 20, h.PartitionCount())
+	})
+	t.Run("defaults", func(t *testing.T) {
+		h, err := NewWithOptions(WithPartitionCount(10))
+		require.NoError(t, err)
+		c := h.(*cHash[Member]).config
+		assert.Equal(t, 1, c.ReplicationFactor)
+		assert.Equal(t, defaultMultiplyFactor, c.MultiplyFactor)
+	})
+	t.Run("config fields", func(t *testing.T) {
+		h, err := NewWithOptions(
+			WithPartitionCount(10),
+			WithLazy(true),
+			WithCoalesceWindow(time.Second),
+			WithUnsafeStringHash(true),
+			WithNormalizeCapacity(true),
+			WithMaxVirtualMembers(100),
+		)
+		require.NoError(t, err)
+		c := h.(*cHash[Member]).config
+		assert.True(t, c.Lazy)
+		assert.Equal(t, time.Second, c.CoalesceWindow)
+		assert.True(t, c.UnsafeStringHash)
+		assert.True(t, c.NormalizeCapacity)
+		assert.Equal(t, 100, c.MaxVirtualMembers)
+
+		// lazy mode can't limit replica changes
+		h, err = NewWithOptions(WithPartitionCount(10), WithMaxReplicaChangePerPartition(1))
+		require.NoError(t, err)
+		assert.Equal(t, 1, h.(*cHash[Member]).config.MaxReplicaChangePerPartition)
+	})
+	t.Run("invalid values", func(t *testing.T) {
+		_, err := NewWithOptions()
+		assert.EqualError(t, err, "partition count must be greater or equal 10")
+		_, err = NewWithOptions(WithPartitionCount(10), WithReplicationFactor(-1))
+		assert.EqualError(t, err, "replication factor must be greater or equal 1")
+		_, err = NewWithOptions(WithPartitionCount(10), WithLoadFactor(0.5))
+		assert.EqualError(t, err, "load factor must be greater or equal 1")
+	})
+}
@@ -0,0 +1,83 @@
+package chash
+
+import "golang.org/x/exp/maps"
+
+func (c *cHash[M]) PinKey(key, memberId string) error {
+	c.lock()
+	defer c.unlock()
+	m, ok := c.members[memberId]
+	if !ok {
+		return ErrMemberNotExists
+	}
+	var pins = make(map[string][]M)
+	if p := c.pins.Load(); p != nil {
+		pins = maps.Clone(*p)
+	}
+	pins[key] = []M{m}
+	c.storePins(pins)
+	return nil
+}
+
+func (c *cHash[M]) UnpinKey(key string) {
+	c.lock()
+	defer c.unlock()
+	p := c.pins.Load()
+	if p == nil {
+		return
+	}
+	if _, ok := (*p)[key]; !ok {
+		return
+	}
+	pins := maps.Clone(*p)
+	delete(pins, key)
+	c.storePins(pins)
+}
+
+// renamePins pins keys of the member with oldId to m
+func (c *cHash[M]) renamePins(oldId string, m M) {
+	p := c.pins.Load()
+	if p == nil {
+		return
+	}
+	var pins = maps.Clone(*p)
+	for key, ms := range pins {
+		if ms[0].Id() == oldId {
+			pins[key] = []M{m}
+		}
+	}
+	c.storePins(pins)
+}
+
+// refreshPins drops pins of removed members and replaces pinned members with the current ones having the same id
+func (c *cHash[M]) refreshPins() {
+	p := c.pins.Load()
+	if p == nil {
+		return
+	}
+	var pins = make(map[string][]M, len(*p))
+	for key, ms := range *p {
+		if m, ok := c.members[ms[0].Id()]; ok {
+			pins[key] = []M{m}
+		}
+	}
+	c.storePins(pins)
+}
+
+// storePins publishes pins for lookups, no pins are stored as nil, so lookups of rings without pins check only the pointer
+func (c *cHash[M]) storePins(pins map[string][]M) {
+	if len(pins) == 0 {
+		c.pins.Store(nil)
+		return
+	}
+	c.pins.Store(&pins)
+}
+
+// pinned returns the pinned member of the key
+func (c *cHash[M]) pinned(key string) ([]M, bool) {
+	p := c.pins.Load()
+	if p == nil {
+		return nil, false
+	}
+	ms, ok := (*p)[key]
+	return ms, ok
+}
@@ -0,0 +1,75 @@
+package chash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+func TestCHash_PinKey(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}, testMember{id: "3", cap: 1}))
+	// the key whose partition isn't owned by the pinned member
+	var key string
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprint("k", i)
+		if !slices.Contains(memberIds(h.GetMembers(k)), "3") {
+			key = k
+		}
+	}
+	assert.Equal(t, ErrMemberNotExists, h.PinKey(key, "4"))
+
+	require.NoError(t, h.PinKey(key, "3"))
+	assertPinned := func(t *testing.T, h CHash, id string) {
+		ms := h.GetMembers(key)
+		require.Len(t, ms, 1)
+		assert.Equal(t, id, ms[0].Id())
+		m, ok := h.GetPrimary(key)
+		require.True(t, ok)
+		assert.Equal(t, id, m.Id())
+		assert.Equal(t, ms, h.GetMembersBytes([]byte(key)))
+		assert.Equal(t, [][]Member{ms}, h.GetMembersMany([]string{key}))
+	}
+	assertPinned(t, h, "3")
+	assert.NotEqual(t, h.GetMembers(key), h.GetMembers("other"))
+
+	t.Run("reconfigure", func(t *testing.T) {
+		h := h.Clone()
+		assertPinned(t, h, "3")
+		require.NoError(t, h.Reconfigure([]Member{testMember{id: "3", cap: 2}, testMember{id: "4", cap: 1}, testMember{id: "5", cap: 1}}))
+		assertPinned(t, h, "3")
+		assert.Equal(t, float64(2), h.GetMembers(key)[0].Capacity())
+		require.NoError(t, h.AddMembers(testMember{id: "6", cap: 1}))
+		require.NoError(t, h.DrainMember("3"))
+		assertPinned(t, h, "3")
+	})
+	t.Run("moved member", func(t *testing.T) {
+		h := h.Clone()
+		require.NoError(t, h.MoveMember("3", testMember{id: "3a", cap: 1}))
+		assertPinned(t, h, "3a")
+		require.NoError(t, h.MoveMember("3a", testMember{id: "3a", cap: 2}))
+		assertPinned(t, h, "3a")
+		assert.Equal(t, float64(2), h.GetMembers(key)[0].Capacity())
+	})
+	t.Run("removed member", func(t *testing.T) {
+		h := h.Clone()
+		require.NoError(t, h.RemoveMembers("3"))
+		assert.Len(t, h.GetMembers(key), 2)
+		require.NoError(t, h.AddMembers(testMember{id: "3", cap: 1}))
+		assert.False(t, slices.Contains(memberIds(h.GetMembers(key)), "3"))
+	})
+	t.Run("unpin", func(t *testing.T) {
+		h := h.Clone()
+		h.UnpinKey(key)
+		h.UnpinKey("unknown")
+		assert.Len(t, h.GetMembers(key), 2)
+		assert.False(t, slices.Contains(memberIds(h.GetMembers(key)), "3"))
+	})
+}
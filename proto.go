@@ -0,0 +1,261 @@
+package chash
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrHasherMismatch is returned by UnmarshalProto when the data was encoded by a ring using another hasher
+var ErrHasherMismatch = errors.New("ring data is encoded for another hasher")
+
+// defaultHasherName is the name of the default hasher in RingState
+const defaultHasherName = "xxhash64"
+
+// protobuf wire types used by RingState
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// field numbers of ringstate.proto
+const (
+	fieldPartitionCount         = 1
+	fieldReplicationFactor      = 2
+	fieldMultiplyFactor         = 3
+	fieldMembers                = 4
+	fieldPartitions             = 5
+	fieldHasher                 = 6
+	fieldSeed                   = 7
+	fieldLoadFactor             = 8
+	fieldOverflowTolerance      = 9
+	fieldMaxPartitionsPerMember = 10
+	fieldLazy                   = 11
+	fieldNormalizeCapacity      = 12
+	fieldMaxVirtualMembers      = 13
+
+	fieldMemberId         = 1
+	fieldMemberCapacity   = 2
+	fieldMemberDrained    = 3
+	fieldMemberStandby    = 4
+	fieldMemberPositionId = 5
+
+	fieldPartitionMemberIds = 1
+)
+
+// placementConfig is the part of the config affecting placement that only RingState carries
+type placementConfig struct {
+	Seed                   uint64
+	LoadFactor             float64
+	OverflowTolerance      float64
+	MaxPartitionsPerMember int
+	Lazy                   bool
+	NormalizeCapacity      bool
+	MaxVirtualMembers      int
+}
+
+func (c *cHash[M]) MarshalProto() (data []byte, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	data = appendProtoVarint(data, fieldPartitionCount, c.config.PartitionCount)
+	data = appendProtoVarint(data, fieldReplicationFactor, uint64(c.config.ReplicationFactor))
+	data = appendProtoVarint(data, fieldMultiplyFactor, uint64(c.config.MultiplyFactor))
+	var buf []byte
+	for _, id := range c.sortedIds() {
+		buf = appendProtoString(buf[:0], fieldMemberId, id)
+		buf = appendProtoDouble(buf, fieldMemberCapacity, c.weight(c.members[id]))
+		if _, drained := c.drained[id]; drained {
+			buf = appendProtoBool(buf, fieldMemberDrained, true)
+		}
+		if _, standby := c.standby[id]; standby {
+			buf = appendProtoBool(buf, fieldMemberStandby, true)
+		}
+		if positionId, moved := c.positionIds[id]; moved {
+			buf = appendProtoString(buf, fieldMemberPositionId, positionId)
+		}
+		data = appendProtoBytes(data, fieldMembers, buf)
+	}
+	if len(c.members) != 0 {
+		for _, ms := range c.snapshot.Load().table() {
+			buf = buf[:0]
+			for _, m := range ms {
+				buf = appendProtoString(buf, fieldPartitionMemberIds, m.Id())
+			}
+			data = appendProtoBytes(data, fieldPartitions, buf)
+		}
+	}
+	if _, ok := c.config.Hasher.(defaultHasher); ok {
+		data = appendProtoString(data, fieldHasher, defaultHasherName)
+	}
+	data = appendProtoVarint(data, fieldSeed, c.config.Seed)
+	data = appendProtoDouble(data, fieldLoadFactor, c.config.LoadFactor)
+	data = appendProtoDouble(data, fieldOverflowTolerance, c.config.OverflowTolerance)
+	data = appendProtoVarint(data, fieldMaxPartitionsPerMember, uint64(c.config.MaxPartitionsPerMember))
+	data = appendProtoBool(data, fieldLazy, c.config.Lazy)
+	data = appendProtoBool(data, fieldNormalizeCapacity, c.config.NormalizeCapacity)
+	data = appendProtoVarint(data, fieldMaxVirtualMembers, uint64(c.config.MaxVirtualMembers))
+	return data, nil
+}
+
+func (c *cHash[M]) UnmarshalProto(data []byte) (err error) {
+	var state = ringState{placement: &placementConfig{}}
+	var hasher string
+	err = readProto(data, func(field int, wire int, v uint64, b []byte) error {
+		switch {
+		case field == fieldPartitionCount && wire == wireVarint:
+			state.PartitionCount = v
+		case field == fieldReplicationFactor && wire == wireVarint:
+			state.ReplicationFactor = int(v)
+		case field == fieldMultiplyFactor && wire == wireVarint:
+			state.MultiplyFactor = int(v)
+		case field == fieldMembers && wire == wireBytes:
+			return state.readProtoMember(b)
+		case field == fieldHasher && wire == wireBytes:
+			hasher = string(b)
+		case field == fieldSeed && wire == wireVarint:
+			state.placement.Seed = v
+		case field == fieldLoadFactor && wire == wireFixed64:
+			state.placement.LoadFactor = math.Float64frombits(v)
+		case field == fieldOverflowTolerance && wire == wireFixed64:
+			state.placement.OverflowTolerance = math.Float64frombits(v)
+		case field == fieldMaxPartitionsPerMember && wire == wireVarint:
+			state.placement.MaxPartitionsPerMember = int(v)
+		case field == fieldLazy && wire == wireVarint:
+			state.placement.Lazy = v != 0
+		case field == fieldNormalizeCapacity && wire == wireVarint:
+			state.placement.NormalizeCapacity = v != 0
+		case field == fieldMaxVirtualMembers && wire == wireVarint:
+			state.placement.MaxVirtualMembers = int(v)
+		}
+		// partitions are computed from members, unknown fields are skipped like protobuf does
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if _, ok := c.config.Hasher.(defaultHasher); hasher == defaultHasherName && !ok {
+		return ErrHasherMismatch
+	}
+	return c.restore(state)
+}
+
+// readProtoMember appends the member encoded as the Member message to the state
+func (state *ringState) readProtoMember(data []byte) error {
+	var m SerializableMember
+	var drained, standby bool
+	var positionId string
+	err := readProto(data, func(field int, wire int, v uint64, b []byte) error {
+		switch {
+		case field == fieldMemberId && wire == wireBytes:
+			m.MemberId = string(b)
+		case field == fieldMemberCapacity && wire == wireFixed64:
+			m.MemberCapacity = math.Float64frombits(v)
+		case field == fieldMemberDrained && wire == wireVarint:
+			drained = v != 0
+		case field == fieldMemberStandby && wire == wireVarint:
+			standby = v != 0
+		case field == fieldMemberPositionId && wire == wireBytes:
+			positionId = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	state.Members = append(state.Members, m)
+	if drained {
+		state.Drained = append(state.Drained, m.MemberId)
+	}
+	if standby {
+		state.Standby = append(state.Standby, m.MemberId)
+	}
+	if positionId != "" {
+		if state.PositionIds == nil {
+			state.PositionIds = make(map[string]string)
+		}
+		state.PositionIds[m.MemberId] = positionId
+	}
+	return nil
+}
+
+// readProto calls f for every field of the protobuf message, v is the value of numeric fields and b is the value of length-delimited ones
+func readProto(data []byte, f func(field int, wire int, v uint64, b []byte) error) error {
+	for len(data) != 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 || tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+			return errInvalidData
+		}
+		data = data[n:]
+		var v uint64
+		var b []byte
+		wire := int(tag & 7)
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return errInvalidData
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errInvalidData
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errInvalidData
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return errInvalidData
+			}
+			b, data = data[n:n+int(l)], data[n+int(l):]
+		default:
+			return errInvalidData
+		}
+		if err := f(int(tag>>3), wire, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendProtoVarint appends the varint field, zero values are omitted like proto3 does
+func appendProtoVarint(data []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return data
+	}
+	data = binary.AppendUvarint(data, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(data, v)
+}
+
+func appendProtoBool(data []byte, field int, v bool) []byte {
+	if !v {
+		return data
+	}
+	return appendProtoVarint(data, field, 1)
+}
+
+func appendProtoDouble(data []byte, field int, v float64) []byte {
+	if v == 0 {
+		return data
+	}
+	data = binary.AppendUvarint(data, uint64(field)<<3|wireFixed64)
+	return binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+}
+
+// appendProtoString appends the string field even when it's empty, so values of repeated fields are never lost
+func appendProtoString(data []byte, field int, v string) []byte {
+	data = binary.AppendUvarint(data, uint64(field)<<3|wireBytes)
+	data = binary.AppendUvarint(data, uint64(len(v)))
+	return append(data, v...)
+}
+
+func appendProtoBytes(data []byte, field int, v []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(field)<<3|wireBytes)
+	data = binary.AppendUvarint(data, uint64(len(v)))
+	return append(data, v...)
+}
@@ -0,0 +1,106 @@
+package chash
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_MarshalProto(t *testing.T) {
+	h1, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+		MultiplyFactor:    500,
+		OverflowTolerance: 2,
+		Seed:              42,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h1.AddMembers(
+		testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}, testMember{id: "4", cap: 1},
+		standbyMember{testMember{id: "s", cap: 1}},
+	))
+	require.NoError(t, h1.UpdateCapacity("3", 1.5))
+	require.NoError(t, h1.DrainMember("4"))
+	require.NoError(t, h1.ReplaceMember("1", testMember{id: "5", cap: 1}))
+	data, err := h1.MarshalProto()
+	require.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.UnmarshalProto(data))
+		assert.True(t, h1.Equal(h2))
+		assert.Equal(t, memberIds(h1.Members()), memberIds(h2.Members()))
+		assert.Equal(t, h1.MemberPositions("5"), h2.MemberPositions("5"))
+		assert.Empty(t, h2.PartitionsOwnedBy("4"))
+		assert.Empty(t, h2.PartitionsOwnedBy("s"))
+		assert.Equal(t, uint64(1), h2.Version())
+		data2, err := h2.MarshalProto()
+		require.NoError(t, err)
+		assert.Equal(t, data, data2)
+	})
+	t.Run("partition table", func(t *testing.T) {
+		var partitions [][]string
+		require.NoError(t, readProto(data, func(field int, wire int, v uint64, b []byte) error {
+			if field != fieldPartitions {
+				return nil
+			}
+			var ids []string
+			err := readProto(b, func(field int, wire int, v uint64, b []byte) error {
+				assert.Equal(t, fieldPartitionMemberIds, field)
+				ids = append(ids, string(b))
+				return nil
+			})
+			partitions = append(partitions, ids)
+			return err
+		}))
+		require.Len(t, partitions, h1.PartitionCount())
+		for i, ids := range partitions {
+			ms, err := h1.GetPartitionMembers(i)
+			require.NoError(t, err)
+			assert.Equal(t, memberIds(ms), ids)
+		}
+	})
+	t.Run("unknown fields", func(t *testing.T) {
+		extended := appendProtoString(data, 100, "future")
+		extended = binary.AppendUvarint(extended, 101<<3|wireFixed32)
+		extended = binary.LittleEndian.AppendUint32(extended, 1)
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h2.UnmarshalProto(extended))
+		assert.True(t, h1.Equal(h2))
+	})
+	t.Run("invalid data", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		assert.Error(t, h2.UnmarshalProto(nil))
+		assert.Error(t, h2.UnmarshalProto(data[:len(data)-1]))
+		assert.Error(t, h2.UnmarshalProto([]byte{fieldMembers<<3 | wireBytes, 10}))
+	})
+	t.Run("another hasher", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 10, Hasher: fnvHasher{}})
+		require.NoError(t, err)
+		assert.Equal(t, ErrHasherMismatch, h2.UnmarshalProto(data))
+
+		require.NoError(t, h2.AddMembers(testMember{id: "a", cap: 1}))
+		data, err := h2.MarshalProto()
+		require.NoError(t, err)
+		h3, err := New(Config{PartitionCount: 10, Hasher: fnvHasher{}})
+		require.NoError(t, err)
+		require.NoError(t, h3.UnmarshalProto(data))
+		assert.True(t, h2.Equal(h3))
+	})
+	t.Run("lazy", func(t *testing.T) {
+		h2, err := New(Config{PartitionCount: 100, ReplicationFactor: 2, Lazy: true})
+		require.NoError(t, err)
+		require.NoError(t, h2.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}))
+		data, err := h2.MarshalProto()
+		require.NoError(t, err)
+		h3, err := New(Config{PartitionCount: 10})
+		require.NoError(t, err)
+		require.NoError(t, h3.UnmarshalProto(data))
+		assert.True(t, h2.Equal(h3))
+	})
+}
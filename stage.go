@@ -0,0 +1,78 @@
+package chash
+
+import (
+	"context"
+
+	"golang.org/x/exp/slices"
+)
+
+func (c *cHash[M]) StageReconfigure(members []M) error {
+	c.lock()
+	defer c.unlock()
+	if err := validateMembers(members, map[string]M(nil)); err != nil {
+		return err
+	}
+	// the copy has no namespaces, so their replication factors are checked by the ring
+	if err := c.checkReplication(len(members), c.config.ReplicationFactor); err != nil {
+		return err
+	}
+	staged := c.clone()
+	if err := staged.Reconfigure(members); err != nil {
+		return err
+	}
+	c.staged = staged
+	return nil
+}
+
+func (c *cHash[M]) PendingDiff() []PartitionChange {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.staged == nil {
+		return nil
+	}
+	return Diff(c.snapshot.Load().table(), c.staged.snapshot.Load().table())
+}
+
+func (c *cHash[M]) CommitStaged() error {
+	c.lock()
+	defer c.unlock()
+	staged := c.staged
+	if staged == nil {
+		return ErrNotStaged
+	}
+	c.members = staged.members
+	c.capacities = staged.capacities
+	c.membersSet = staged.membersSet
+	c.drained = staged.drained
+	c.standby = staged.standby
+	c.spares, c.idleStandby = staged.spares, staged.idleStandby
+	c.positionIds = staged.positionIds
+	c.unit = staged.unit
+	c.pending = nil
+	if c.config.Lazy {
+		// lazy partitions are cheap to prepare again, and they aren't computed yet anyway
+		c.distributeLazy()
+		return nil
+	}
+	var nsPartitions = make([][][]M, len(c.namespaces))
+	for i, ns := range c.namespaces {
+		nsPartitions[i], _ = c.buildPartitions(context.Background(), ns.rf)
+	}
+	// pieces left by the staged distribution are the ones of the published table
+	c.piecesPerMember, c.ownedPieces = staged.piecesPerMember, staged.ownedPieces
+	c.zones, c.zoneCount = staged.zones, staged.zoneCount
+	c.violations = staged.violations
+	// the table is flattened again by the new snapshot, so the staged one keeps its own partitions
+	c.publish(slices.Clone(staged.snapshot.Load().table()))
+	for i, ns := range c.namespaces {
+		ns.snapshot.Store(c.newSnapshot(nsPartitions[i]))
+	}
+	c.distributed()
+	return nil
+}
+
+func (c *cHash[M]) AbortStaged() {
+	c.lock()
+	defer c.unlock()
+	c.staged = nil
+}
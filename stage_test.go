@@ -0,0 +1,89 @@
+package chash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_StageReconfigure(t *testing.T) {
+	var members []Member
+	for i := 0; i < 4; i++ {
+		members = append(members, testMember{id: fmt.Sprint(i), cap: 1})
+	}
+	newRing := func(t *testing.T) CHash {
+		h, err := New(Config{
+			PartitionCount:    100,
+			ReplicationFactor: 2,
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(members[:3]...))
+		return h
+	}
+	next := []Member{members[1], members[2], members[3]}
+
+	t.Run("commit", func(t *testing.T) {
+		h := newRing(t)
+		expected := h.Clone()
+		require.NoError(t, expected.Reconfigure(next))
+		before, version := h.Partitions(), h.Version()
+		assert.Nil(t, h.PendingDiff())
+
+		require.NoError(t, h.StageReconfigure(next))
+		assert.Equal(t, before, h.Partitions())
+		assert.Equal(t, version, h.Version())
+		assert.True(t, h.ContainsMember("0"))
+		diff := h.PendingDiff()
+		assert.Equal(t, Diff(before, expected.Partitions()), diff)
+		assert.NotEmpty(t, diff)
+
+		require.NoError(t, h.CommitStaged())
+		assert.Equal(t, version+1, h.Version())
+		assert.True(t, expected.Equal(h))
+		assert.False(t, h.ContainsMember("0"))
+		assert.Nil(t, h.PendingDiff())
+		assert.Equal(t, ErrNotStaged, h.CommitStaged())
+
+		// the committed ring keeps working like a reconfigured one
+		require.NoError(t, h.AddMembers(members[0]))
+		require.NoError(t, expected.AddMembers(members[0]))
+		assert.True(t, expected.Equal(h))
+	})
+	t.Run("abort", func(t *testing.T) {
+		h := newRing(t)
+		before := h.Partitions()
+		require.NoError(t, h.StageReconfigure(next))
+		h.AbortStaged()
+		assert.Nil(t, h.PendingDiff())
+		assert.Equal(t, ErrNotStaged, h.CommitStaged())
+		assert.Equal(t, before, h.Partitions())
+	})
+	t.Run("dropped by changes", func(t *testing.T) {
+		h := newRing(t)
+		require.NoError(t, h.StageReconfigure(next))
+		require.NoError(t, h.RemoveMembers("2"))
+		assert.Nil(t, h.PendingDiff())
+		assert.Equal(t, ErrNotStaged, h.CommitStaged())
+		assert.True(t, h.ContainsMember("0"))
+	})
+	t.Run("namespaces", func(t *testing.T) {
+		h := newRing(t)
+		ns, err := h.Namespace(1)
+		require.NoError(t, err)
+		require.NoError(t, h.StageReconfigure(next))
+		require.NoError(t, h.CommitStaged())
+		for i := 0; i < 100; i++ {
+			ms := ns.GetMembers(fmt.Sprint(i))
+			require.Len(t, ms, 1)
+			assert.NotEqual(t, "0", ms[0].Id())
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		h := newRing(t)
+		assert.Equal(t, ErrMemberExists, h.StageReconfigure([]Member{members[0], members[0]}))
+		assert.Equal(t, ErrInvalidCapacity, h.StageReconfigure([]Member{testMember{id: "x"}}))
+		assert.Nil(t, h.PendingDiff())
+	})
+}
@@ -0,0 +1,268 @@
+package chash
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+// BalanceStats describes how evenly partitions are distributed by members
+type BalanceStats struct {
+	// MinPartitions - the smallest count of partitions owned by a member
+	MinPartitions int
+	// MaxPartitions - the biggest count of partitions owned by a member
+	MaxPartitions int
+	// MeanPartitions - average count of partitions per member
+	MeanPartitions float64
+	// CV - coefficient of variation (standard deviation divided by mean) of partitions per member, 0 means perfect balance
+	CV float64
+}
+
+// Stats describes the ring state, e.g. for metrics export
+type Stats struct {
+	// MemberCount - count of members including drained ones
+	MemberCount int
+	// PartitionCount - count of partitions
+	PartitionCount int
+	// EffectiveReplicationFactor - the replication factor limited by count of members owning partitions
+	EffectiveReplicationFactor int
+	// VirtualMembers - count of virtual members in the hash ring
+	VirtualMembers int
+	// FullyReplicated - every partition has as many members as the configured replication factor
+	FullyReplicated bool
+	BalanceStats
+}
+
+// MemberLoad is a member of CHash with count of its partitions
+type MemberLoad = MemberLoadG[Member]
+
+// MemberLoadG is a member with count of partitions containing it, see CHashG.TopMembersByLoad
+type MemberLoadG[M Member] struct {
+	Member     M
+	Partitions int
+}
+
+func (c *cHash[M]) PartitionsOwnedBy(id string) []int {
+	var owned = []int{}
+	for i, ms := range c.snapshot.Load().table() {
+		for _, m := range ms {
+			if m.Id() == id {
+				owned = append(owned, i)
+				break
+			}
+		}
+	}
+	return owned
+}
+
+func (c *cHash[M]) UnderReplicatedPartitions() []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.underReplicatedPartitions(c.snapshot.Load().table())
+}
+
+func (c *cHash[M]) underReplicatedPartitions(partitions [][]M) []int {
+	var under = []int{}
+	for i, ms := range partitions {
+		if len(ms) < c.config.ReplicationFactor {
+			under = append(under, i)
+		}
+	}
+	return under
+}
+
+func (c *cHash[M]) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	partitions := c.snapshot.Load().table()
+	return Stats{
+		MemberCount:                len(c.members),
+		PartitionCount:             len(partitions),
+		EffectiveReplicationFactor: c.effectiveReplicationFactor(),
+		VirtualMembers:             c.membersSet.Len(),
+		FullyReplicated:            len(c.underReplicatedPartitions(partitions)) == 0,
+		BalanceStats:               c.balanceStats(partitions),
+	}
+}
+
+func (c *cHash[M]) LoadDistribution() map[string]float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var (
+		total int
+		load  = make(map[string]float64, len(c.members))
+	)
+	for id := range c.members {
+		load[id] = 0
+	}
+	for _, ms := range c.snapshot.Load().table() {
+		for _, m := range ms {
+			load[m.Id()]++
+			total++
+		}
+	}
+	if total == 0 {
+		return load
+	}
+	for id := range load {
+		load[id] /= float64(total)
+	}
+	return load
+}
+
+func (c *cHash[M]) BalanceStats() BalanceStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.balanceStats(c.snapshot.Load().table())
+}
+
+func (c *cHash[M]) balanceStats(partitions [][]M) (stats BalanceStats) {
+	if c.placeableCount() == 0 {
+		return
+	}
+	var counts = make(map[string]int, len(c.members))
+	for id := range c.members {
+		if c.isPlaceable(id) {
+			counts[id] = 0
+		}
+	}
+	var total int
+	for _, ms := range partitions {
+		for _, m := range ms {
+			counts[m.Id()]++
+			total++
+		}
+	}
+	stats.MinPartitions = math.MaxInt
+	stats.MeanPartitions = float64(total) / float64(len(counts))
+	var variance float64
+	// the variance is summed by id, so equal rings give equal stats
+	var ids = maps.Keys(counts)
+	sort.Strings(ids)
+	for _, id := range ids {
+		count := counts[id]
+		if count < stats.MinPartitions {
+			stats.MinPartitions = count
+		}
+		if count > stats.MaxPartitions {
+			stats.MaxPartitions = count
+		}
+		variance += (float64(count) - stats.MeanPartitions) * (float64(count) - stats.MeanPartitions)
+	}
+	if stats.MeanPartitions > 0 {
+		stats.CV = math.Sqrt(variance/float64(len(counts))) / stats.MeanPartitions
+	}
+	return
+}
+
+func (c *cHash[M]) TopMembersByLoad(n int) []MemberLoadG[M] {
+	if n <= 0 {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var (
+		counts = partitionCounts(c.snapshot.Load().table())
+		loads  = make([]MemberLoadG[M], 0, len(c.members))
+	)
+	for id, m := range c.members {
+		loads = append(loads, MemberLoadG[M]{Member: m, Partitions: counts[id]})
+	}
+	sort.Slice(loads, func(i, j int) bool {
+		if loads[i].Partitions == loads[j].Partitions {
+			return loads[i].Member.Id() < loads[j].Member.Id()
+		}
+		return loads[i].Partitions > loads[j].Partitions
+	})
+	if n < len(loads) {
+		loads = loads[:n]
+	}
+	return loads
+}
+
+func (c *cHash[M]) OrphanMembers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var (
+		counts  = partitionCounts(c.snapshot.Load().table())
+		orphans = []string{}
+	)
+	for _, id := range c.sortedIds() {
+		if counts[id] == 0 {
+			orphans = append(orphans, id)
+		}
+	}
+	return orphans
+}
+
+func (c *cHash[M]) ReplicationGraph() map[string][]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var peers = make(map[string]map[string]struct{}, len(c.members))
+	for id := range c.members {
+		peers[id] = map[string]struct{}{}
+	}
+	for _, ms := range c.snapshot.Load().table() {
+		for _, m1 := range ms {
+			for _, m2 := range ms {
+				if m1.Id() != m2.Id() {
+					peers[m1.Id()][m2.Id()] = struct{}{}
+				}
+			}
+		}
+	}
+	var graph = make(map[string][]string, len(peers))
+	for id, set := range peers {
+		ids := make([]string, 0, len(set))
+		for peer := range set {
+			ids = append(ids, peer)
+		}
+		sort.Strings(ids)
+		graph[id] = ids
+	}
+	return graph
+}
+
+// partitionCounts returns count of partitions containing every member, members without partitions are absent
+func partitionCounts[M Member](partitions [][]M) map[string]int {
+	var counts = make(map[string]int)
+	for _, ms := range partitions {
+		for _, m := range ms {
+			counts[m.Id()]++
+		}
+	}
+	return counts
+}
+
+func (c *cHash[M]) Dump() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var (
+		b          strings.Builder
+		partitions = c.snapshot.Load().table()
+		counts     = partitionCounts(partitions)
+	)
+	fmt.Fprintf(&b, "partitions: %d, replication factor: %d, members: %d\n", len(partitions), c.config.ReplicationFactor, len(c.members))
+	for _, id := range c.sortedIds() {
+		fmt.Fprintf(&b, "member %s: capacity %g, partitions %d", id, c.weight(c.members[id]), counts[id])
+		if _, drained := c.drained[id]; drained {
+			b.WriteString(", drained")
+		}
+		if _, standby := c.standby[id]; standby {
+			b.WriteString(", standby")
+		}
+		b.WriteByte('\n')
+	}
+	for i, ms := range partitions {
+		fmt.Fprintf(&b, "%d:", i)
+		for _, m := range ms {
+			b.WriteByte(' ')
+			b.WriteString(m.Id())
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
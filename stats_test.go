@@ -0,0 +1,235 @@
+package chash
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+func TestCHash_LoadDistribution(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 1,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, h.LoadDistribution())
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 3}))
+
+	load := h.LoadDistribution()
+	require.Len(t, load, 3)
+	var total float64
+	for _, l := range load {
+		total += l
+	}
+	assert.InDelta(t, 1, total, 0.0001)
+	assert.InDelta(t, 1.0/6, load["1"], 0.02)
+	assert.InDelta(t, 2.0/6, load["2"], 0.02)
+	assert.InDelta(t, 3.0/6, load["3"], 0.02)
+}
+
+func TestCHash_PartitionsOwnedBy(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 1}))
+
+	var covered = make(map[int]int)
+	for _, m := range h.Members() {
+		owned := h.PartitionsOwnedBy(m.Id())
+		assert.IsIncreasing(t, owned)
+		for _, p := range owned {
+			covered[p]++
+		}
+	}
+	require.Len(t, covered, h.PartitionCount())
+	for _, count := range covered {
+		assert.Equal(t, 2, count)
+	}
+	assert.NotNil(t, h.PartitionsOwnedBy("unknown"))
+	assert.Empty(t, h.PartitionsOwnedBy("unknown"))
+}
+
+func TestCHash_UnderReplicatedPartitions(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    100,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	assert.Len(t, h.UnderReplicatedPartitions(), 100)
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	under := h.UnderReplicatedPartitions()
+	require.Len(t, under, 100)
+	for i, p := range under {
+		assert.Equal(t, i, p)
+	}
+	require.NoError(t, h.AddMembers(testMember{id: "3", cap: 1}))
+	assert.Empty(t, h.UnderReplicatedPartitions())
+}
+
+func TestCHash_BalanceStats(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, BalanceStats{}, h.BalanceStats())
+	for i := 0; i < 20; i++ {
+		require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+	}
+	stats := h.BalanceStats()
+	t.Logf("%+v", stats)
+	assert.Equal(t, float64(450), stats.MeanPartitions)
+	assert.LessOrEqual(t, stats.MinPartitions, 450)
+	assert.GreaterOrEqual(t, stats.MaxPartitions, 450)
+	assert.Less(t, stats.CV, 0.05)
+}
+
+func TestCHash_Dump(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "a", cap: 1}, testMember{id: "b", cap: 2}, testMember{id: "c", cap: 1}))
+	dump := h.Dump()
+	assert.Equal(t, dump, h.Dump())
+	assert.Contains(t, dump, "partitions: 10, replication factor: 2, members: 3\n")
+	for id, capacity := range map[string]int{"a": 1, "b": 2, "c": 1} {
+		assert.Contains(t, dump, fmt.Sprintf("member %s: capacity %d, partitions %d\n", id, capacity, len(h.PartitionsOwnedBy(id))))
+	}
+	ms, _ := h.GetPartitionMembers(3)
+	assert.Contains(t, dump, fmt.Sprintf("\n3: %s %s\n", ms[0].Id(), ms[1].Id()))
+}
+
+func TestCHash_Stats(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 3,
+		MultiplyFactor:    10,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.AddMembers(testMember{id: "a", cap: 1}, testMember{id: "b", cap: 2}))
+	stats := h.Stats()
+	assert.Equal(t, Stats{
+		MemberCount:                2,
+		PartitionCount:             10,
+		EffectiveReplicationFactor: 2,
+		VirtualMembers:             30,
+		FullyReplicated:            false,
+		BalanceStats:               h.BalanceStats(),
+	}, stats)
+	assert.Equal(t, 10, stats.MinPartitions)
+	assert.Equal(t, 10, stats.MaxPartitions)
+
+	require.NoError(t, h.AddMembers(testMember{id: "c", cap: 1}))
+	stats = h.Stats()
+	assert.True(t, stats.FullyReplicated)
+	assert.Equal(t, 3, stats.EffectiveReplicationFactor)
+	assert.Equal(t, 40, stats.VirtualMembers)
+	assert.Equal(t, 10.0, stats.MeanPartitions)
+}
+
+func TestCHash_TopMembersByLoad(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    1000,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, h.TopMembersByLoad(3))
+	require.NoError(t, h.AddMembers(
+		testMember{id: "1", cap: 1},
+		testMember{id: "2", cap: 4},
+		testMember{id: "3", cap: 1},
+		testMember{id: "4", cap: 2},
+	))
+	assert.Nil(t, h.TopMembersByLoad(0))
+
+	top := h.TopMembersByLoad(2)
+	require.Len(t, top, 2)
+	assert.Equal(t, "2", top[0].Member.Id())
+	assert.Equal(t, len(h.PartitionsOwnedBy("2")), top[0].Partitions)
+	assert.GreaterOrEqual(t, top[0].Partitions, top[1].Partitions)
+
+	all := h.TopMembersByLoad(10)
+	require.Len(t, all, 4)
+	for i := 1; i < len(all); i++ {
+		assert.True(t, all[i-1].Partitions > all[i].Partitions ||
+			all[i-1].Partitions == all[i].Partitions && all[i-1].Member.Id() < all[i].Member.Id())
+	}
+}
+
+func TestCHash_OrphanMembers(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, h.OrphanMembers())
+	for i := 0; i < 35; i++ {
+		require.NoError(t, h.AddMembers(&testMember{id: fmt.Sprint(i), cap: 1}))
+	}
+	orphans := h.OrphanMembers()
+	// 30 partition slots can't be owned by 35 members
+	assert.GreaterOrEqual(t, len(orphans), 5)
+	assert.True(t, sort.StringsAreSorted(orphans))
+	for _, m := range h.Members() {
+		assert.Equal(t, len(h.PartitionsOwnedBy(m.Id())) == 0, slices.Contains(orphans, m.Id()), m.Id())
+	}
+
+	require.NoError(t, h.DrainMember("0"))
+	assert.Contains(t, h.OrphanMembers(), "0")
+}
+
+func TestCHash_ReplicationGraph(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    3000,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, h.ReplicationGraph())
+	for i := 0; i < 50; i++ {
+		require.NoError(t, h.AddMembers(&testMember{id: fmt.Sprint("n", i), cap: 1}))
+	}
+	graph := h.ReplicationGraph()
+	require.Len(t, graph, 50)
+	t.Run("symmetric", func(t *testing.T) {
+		for id, peers := range graph {
+			assert.True(t, sort.StringsAreSorted(peers))
+			assert.NotContains(t, peers, id)
+			for _, peer := range peers {
+				assert.Contains(t, graph[peer], id)
+			}
+		}
+	})
+	t.Run("degree", func(t *testing.T) {
+		var expected = make(map[string]map[string]bool)
+		for i := 0; i < 3000; i++ {
+			nodes, _ := h.GetPartitionMembers(i)
+			for _, n1 := range nodes {
+				for _, n2 := range nodes {
+					if n1.Id() != n2.Id() {
+						if expected[n1.Id()] == nil {
+							expected[n1.Id()] = make(map[string]bool)
+						}
+						expected[n1.Id()][n2.Id()] = true
+					}
+				}
+			}
+		}
+		var expectedConn, conn int
+		for id, m := range expected {
+			expectedConn += len(m)
+			assert.Len(t, graph[id], len(m))
+		}
+		for _, peers := range graph {
+			conn += len(peers)
+		}
+		assert.Equal(t, expectedConn/len(expected), conn/len(graph))
+	})
+}
@@ -0,0 +1,182 @@
+package chash
+
+import (
+	"math"
+
+	"github.com/cespare/xxhash"
+	"golang.org/x/exp/slices"
+)
+
+// Strategy places members to partitions
+type Strategy interface {
+	// Assign returns indexes of rf distinct members for every partition hash, indexes of a partition are in replica order starting from the primary
+	// members are sorted by id, rf is greater than 0 and not greater than members count
+	Assign(members []StrategyMember, partitionHashes []uint64, rf int) [][]int
+}
+
+// StrategyMember describes a member for Strategy
+type StrategyMember struct {
+	Id string
+	// Weight - the member capacity
+	Weight float64
+}
+
+// RendezvousStrategy places members using weighted rendezvous (highest random weight) hashing
+// It doesn't need virtual members and a membership change moves only partitions of changed members
+type RendezvousStrategy struct{}
+
+func (RendezvousStrategy) Assign(members []StrategyMember, partitionHashes []uint64, rf int) [][]int {
+	var seeds = make([]uint64, len(members))
+	for i, m := range members {
+		seeds[i] = xxhash.Sum64String(m.Id)
+	}
+	var (
+		result = make([][]int, len(partitionHashes))
+		table  = make([]int, len(partitionHashes)*rf)
+		scores = make([]float64, len(members))
+	)
+	for p, h := range partitionHashes {
+		for i, m := range members {
+			scores[i] = rendezvousScore(seeds[i], h, m.Weight)
+		}
+		result[p] = table[p*rf : (p+1)*rf : (p+1)*rf]
+		for j := range result[p] {
+			best := -1
+			for i, score := range scores {
+				if best == -1 || score > scores[best] {
+					best = i
+				}
+			}
+			result[p][j] = best
+			scores[best] = math.Inf(-1)
+		}
+	}
+	return result
+}
+
+// rendezvousScore returns the weighted score of the member for the partition: -weight / ln(u), where u is a uniform hash in (0, 1)
+func rendezvousScore(seed, h uint64, weight float64) float64 {
+	u := (float64(mix64(seed^h)>>11) + 0.5) / (1 << 53)
+	return -weight / math.Log(u)
+}
+
+// mix64 is the splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// MaglevStrategy fills partitions like the Maglev lookup table where every partition is a table entry
+// Members take entries in turns proportional to their weight following their own permutation of partitions,
+// so a membership change moves only a small fraction of partitions
+type MaglevStrategy struct{}
+
+func (MaglevStrategy) Assign(members []StrategyMember, partitionHashes []uint64, rf int) [][]int {
+	var (
+		n       = len(partitionHashes)
+		offsets = make([]int, len(members))
+		skips   = make([]int, len(members))
+		credit  = make([]float64, len(members))
+		next    = make([]int, len(members))
+		result  = make([][]int, n)
+		table   = make([]int, n*rf)
+	)
+	// partition hashes are made by the ring hasher, so permutations depend on Config.Hasher and Config.Seed like the other placements
+	var ringSeed uint64
+	if n > 0 {
+		ringSeed = partitionHashes[0]
+	}
+	var maxWeight float64
+	for i, m := range members {
+		h := mix64(xxhash.Sum64String(m.Id) ^ ringSeed)
+		offsets[i] = int(h % uint64(n))
+		skip := int(mix64(h)%uint64(n-1)) + 1
+		for gcd(skip, n) != 1 {
+			skip = skip%(n-1) + 1
+		}
+		skips[i] = skip
+		if m.Weight > maxWeight {
+			maxWeight = m.Weight
+		}
+	}
+	for p := range result {
+		result[p] = table[p*rf : p*rf : (p+1)*rf]
+	}
+	for replica := 0; replica < rf; replica++ {
+		for i := range members {
+			next[i] = 0
+			credit[i] = 0
+		}
+		var filled int
+		for filled < n {
+			for i, m := range members {
+				credit[i] += m.Weight / maxWeight
+				for credit[i] >= 1 && next[i] < n && filled < n {
+					p := (offsets[i] + next[i]*skips[i]) % n
+					next[i]++
+					if len(result[p]) > replica || slices.Contains(result[p], i) {
+						continue
+					}
+					result[p] = append(result[p], i)
+					credit[i]--
+					filled++
+				}
+			}
+		}
+	}
+	return result
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// JumpStrategy places members using jump consistent hashing, members in id order are the buckets
+// It ignores weights of members, every member gets an equal share of partitions,
+// and adding a member with the greatest id moves only partitions taken by the new member
+// Replicas are taken by jumps of rehashed partition hashes, so they move as rarely as primaries
+type JumpStrategy struct{}
+
+func (JumpStrategy) Assign(members []StrategyMember, partitionHashes []uint64, rf int) [][]int {
+	var (
+		n      = len(members)
+		result = make([][]int, len(partitionHashes))
+		table  = make([]int, len(partitionHashes)*rf)
+	)
+	for p, h := range partitionHashes {
+		result[p] = table[p*rf : p*rf : (p+1)*rf]
+		for replica := 0; replica < rf; replica++ {
+			key := mix64(h + uint64(replica)*0x9e3779b97f4a7c15)
+			bucket := jumpHash(key, n)
+			// a taken bucket is rehashed a few times, then the next free bucket is used, so the loop always ends
+			for attempt := 0; slices.Contains(result[p], bucket); attempt++ {
+				if attempt < n {
+					key = mix64(key)
+					bucket = jumpHash(key, n)
+				} else {
+					bucket = (bucket + 1) % n
+				}
+			}
+			result[p] = append(result[p], bucket)
+		}
+	}
+	return result
+}
+
+// jumpHash returns the bucket in [0, n) of the key by the jump consistent hash of Lamping and Veach
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,239 @@
+package chash
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRendezvousStrategy(t *testing.T) {
+	t.Run("uniq members", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    1000,
+			ReplicationFactor: 3,
+			Strategy:          RendezvousStrategy{},
+		})
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: float64(i%3 + 1)}))
+		}
+		assert.Zero(t, h.(*cHash[Member]).membersSet.Len())
+		for i := 0; i < h.PartitionCount(); i++ {
+			ms, err := h.GetPartitionMembers(i)
+			require.NoError(t, err)
+			var ids = map[string]bool{}
+			for _, m := range ms {
+				ids[m.Id()] = true
+			}
+			assert.Len(t, ids, 3)
+		}
+	})
+	t.Run("capacity proportionality", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    6000,
+			ReplicationFactor: 1,
+			Strategy:          RendezvousStrategy{},
+		})
+		require.NoError(t, err)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 3}))
+		load := h.LoadDistribution()
+		assert.InDelta(t, 1.0/6, load["1"], 0.03)
+		assert.InDelta(t, 2.0/6, load["2"], 0.03)
+		assert.InDelta(t, 3.0/6, load["3"], 0.03)
+	})
+	t.Run("minimal movement", func(t *testing.T) {
+		h, err := New(Config{
+			PartitionCount:    1000,
+			ReplicationFactor: 1,
+			Strategy:          RendezvousStrategy{},
+		})
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+		}
+		before := h.Partitions()
+		require.NoError(t, h.AddMembers(testMember{id: "new", cap: 1}))
+		for _, ch := range Diff(before, h.Partitions()) {
+			assert.Equal(t, []string{"new"}, ch.Added)
+		}
+	})
+}
+
+func TestMaglevStrategy(t *testing.T) {
+	newRing := func(rf int) CHash {
+		h, err := New(Config{
+			PartitionCount:    3001,
+			ReplicationFactor: rf,
+			Strategy:          MaglevStrategy{},
+		})
+		require.NoError(t, err)
+		return h
+	}
+	t.Run("uniq members", func(t *testing.T) {
+		h := newRing(3)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: float64(i%3 + 1)}))
+		}
+		for i := 0; i < h.PartitionCount(); i++ {
+			ms, err := h.GetPartitionMembers(i)
+			require.NoError(t, err)
+			var ids = map[string]bool{}
+			for _, m := range ms {
+				ids[m.Id()] = true
+			}
+			assert.Len(t, ids, 3)
+		}
+	})
+	t.Run("capacity proportionality", func(t *testing.T) {
+		h := newRing(1)
+		require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 2}, testMember{id: "3", cap: 3}))
+		load := h.LoadDistribution()
+		assert.InDelta(t, 1.0/6, load["1"], 0.01)
+		assert.InDelta(t, 2.0/6, load["2"], 0.01)
+		assert.InDelta(t, 3.0/6, load["3"], 0.01)
+	})
+	t.Run("disruption", func(t *testing.T) {
+		h := newRing(1)
+		for i := 0; i < 20; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+		}
+		var keys = make([]string, 10000)
+		var owners = make([]string, len(keys))
+		for i := range keys {
+			keys[i] = fmt.Sprint("k", i)
+			owners[i] = h.GetMembers(keys[i])[0].Id()
+		}
+		require.NoError(t, h.RemoveMembers("n7"))
+		var moved int
+		for i, key := range keys {
+			if owner := h.GetMembers(key)[0].Id(); owner != owners[i] {
+				moved++
+			}
+		}
+		// the minimal possible disruption is 1/20 of keys, Maglev moves slightly more
+		t.Logf("moved keys: %.2f%%", float64(moved)*100/float64(len(keys)))
+		assert.Less(t, float64(moved)/float64(len(keys)), 0.1)
+	})
+	t.Run("seed", func(t *testing.T) {
+		placement := func(seed uint64) [][]string {
+			h, err := New(Config{
+				PartitionCount: 101,
+				Strategy:       MaglevStrategy{},
+				Seed:           seed,
+			})
+			require.NoError(t, err)
+			for i := 0; i < 5; i++ {
+				require.NoError(t, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+			}
+			var ids [][]string
+			for _, ms := range h.Partitions() {
+				ids = append(ids, memberIds(ms))
+			}
+			return ids
+		}
+		assert.Equal(t, placement(1), placement(1))
+		assert.NotEqual(t, placement(0), placement(1))
+		assert.NotEqual(t, placement(1), placement(2))
+	})
+}
+
+func BenchmarkMaglevStrategy_GetMembers(b *testing.B) {
+	for _, strategy := range []Strategy{nil, MaglevStrategy{}} {
+		b.Run(fmt.Sprintf("%T", strategy), func(b *testing.B) {
+			h, err := New(Config{
+				PartitionCount:    3001,
+				ReplicationFactor: 3,
+				Strategy:          strategy,
+			})
+			require.NoError(b, err)
+			for i := 0; i < 30; i++ {
+				require.NoError(b, h.AddMembers(testMember{id: fmt.Sprint("n", i), cap: 1}))
+			}
+			var keys = make([][]byte, 1000)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprint(i))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.GetMembersBytes(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+func TestJumpStrategy(t *testing.T) {
+	newRing := func(t *testing.T, rf, count int) CHash {
+		h, err := New(Config{
+			PartitionCount:    3000,
+			ReplicationFactor: rf,
+			Strategy:          JumpStrategy{},
+		})
+		require.NoError(t, err)
+		for i := 0; i < count; i++ {
+			require.NoError(t, h.AddMembers(testMember{id: fmt.Sprintf("n%02d", i), cap: float64(i%3 + 1)}))
+		}
+		return h
+	}
+	t.Run("uniq members", func(t *testing.T) {
+		h := newRing(t, 3, 4)
+		for i := 0; i < h.PartitionCount(); i++ {
+			ms, err := h.GetPartitionMembers(i)
+			require.NoError(t, err)
+			var ids = map[string]bool{}
+			for _, m := range ms {
+				ids[m.Id()] = true
+			}
+			assert.Len(t, ids, 3)
+		}
+	})
+	t.Run("equal shares", func(t *testing.T) {
+		h := newRing(t, 1, 3)
+		for _, share := range h.LoadDistribution() {
+			assert.InDelta(t, 1.0/3, share, 0.03)
+		}
+	})
+	t.Run("minimal movement", func(t *testing.T) {
+		h := newRing(t, 1, 10)
+		before := h.Partitions()
+		require.NoError(t, h.AddMembers(testMember{id: "n10", cap: 1}))
+		diff := Diff(before, h.Partitions())
+		for _, ch := range diff {
+			assert.Equal(t, []string{"n10"}, ch.Added)
+		}
+		assert.InDelta(t, float64(h.PartitionCount())/11, len(diff), float64(h.PartitionCount())/50)
+	})
+}
+
+func TestJumpHash(t *testing.T) {
+	// values of the reference implementation from the paper
+	for _, v := range []struct {
+		key      uint64
+		expected [4]int
+	}{
+		{0, [4]int{0, 0, 0, 0}},
+		{1, [4]int{0, 6, 55, 549}},
+		{42, [4]int{0, 2, 43, 571}},
+		{0xdeadbeef, [4]int{0, 5, 87, 285}},
+		{math.MaxUint64, [4]int{0, 2, 92, 313}},
+	} {
+		for i, n := range []int{1, 7, 100, 1000} {
+			assert.Equal(t, v.expected[i], jumpHash(v.key, n), "%d %d", v.key, n)
+		}
+	}
+	for n := 1; n < 50; n++ {
+		var moved int
+		for key := uint64(0); key < 1000; key++ {
+			b := jumpHash(mix64(key), n)
+			require.True(t, b >= 0 && b < n)
+			if next := jumpHash(mix64(key), n+1); next != b {
+				assert.Equal(t, n, next)
+				moved++
+			}
+		}
+		assert.InDelta(t, 1000/(n+1), moved, 60)
+	}
+}
@@ -0,0 +1,45 @@
+package chash
+
+import "sync"
+
+// watchers sends versions of the ring to channels returned by Watch
+type watchers struct {
+	chans []chan uint64
+	// last is the latest sent version, concurrent writers may notify out of order
+	last uint64
+	mu   sync.Mutex
+}
+
+func (c *cHash[M]) Version() uint64 {
+	return c.snapshot.Load().version
+}
+
+func (c *cHash[M]) Watch() <-chan uint64 {
+	return c.watchers.add()
+}
+
+func (w *watchers) add() <-chan uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan uint64, 1)
+	w.chans = append(w.chans, ch)
+	return ch
+}
+
+// notify sends the version to every channel replacing a version the receiver didn't take yet
+func (w *watchers) notify(version uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if version <= w.last {
+		return
+	}
+	w.last = version
+	for _, ch := range w.chans {
+		select {
+		case <-ch:
+		default:
+		}
+		// only notify sends to the channel and it's empty now
+		ch <- version
+	}
+}
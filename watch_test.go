@@ -0,0 +1,63 @@
+package chash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCHash_Version(t *testing.T) {
+	h, err := New(Config{
+		PartitionCount:    10,
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), h.Version())
+	watch := h.Watch()
+
+	var versions []uint64
+	require.NoError(t, h.AddMembers(testMember{id: "1", cap: 1}, testMember{id: "2", cap: 1}))
+	versions = append(versions, h.Version())
+	require.NoError(t, h.RemoveMembers("2"))
+	versions = append(versions, h.Version())
+	require.NoError(t, h.Reconfigure([]Member{testMember{id: "3", cap: 1}, testMember{id: "4", cap: 1}}))
+	versions = append(versions, h.Version())
+	h.Distribute()
+	versions = append(versions, h.Version())
+	for i := 1; i < len(versions); i++ {
+		assert.Greater(t, versions[i], versions[i-1])
+	}
+
+	t.Run("failed mutation", func(t *testing.T) {
+		version := h.Version()
+		assert.Error(t, h.AddMembers(testMember{id: "3", cap: 1}))
+		assert.Equal(t, version, h.Version())
+	})
+	t.Run("watch", func(t *testing.T) {
+		select {
+		case v := <-watch:
+			assert.Equal(t, h.Version(), v)
+		case <-time.After(time.Second):
+			t.Fatal("no version received")
+		}
+		select {
+		case v := <-watch:
+			t.Fatalf("unexpected version %d", v)
+		default:
+		}
+		require.NoError(t, h.AddMembers(testMember{id: "5", cap: 1}))
+		assert.Equal(t, h.Version(), <-watch)
+	})
+	t.Run("clone", func(t *testing.T) {
+		clone := h.Clone()
+		assert.Equal(t, h.Version(), clone.Version())
+		require.NoError(t, clone.RemoveMembers("5"))
+		select {
+		case v := <-watch:
+			t.Fatalf("unexpected version %d", v)
+		default:
+		}
+	})
+}